@@ -0,0 +1,14 @@
+package databases
+
+import (
+	"database-ms/config"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func InitRedis(config *config.Configuration) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr: config.RedisUrl + ":" + config.RedisPort,
+		// Password: config.RedisPassword,
+	})
+}
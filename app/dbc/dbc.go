@@ -0,0 +1,193 @@
+package dbc
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Flag set on the ID of messages with an extended frame ID
+const extendedIdFlag = 0x80000000
+
+// Signal value types set by SIG_VALTYPE_
+const (
+	ValueTypeInteger = 0
+	ValueTypeFloat   = 1
+	ValueTypeDouble  = 2
+)
+
+// Database holds the messages and signals described by a DBC file
+type Database struct {
+	Messages []*Message
+}
+
+type Message struct {
+	Id        int64
+	Name      string
+	Size      int
+	Sender    string
+	CycleTime int
+	Signals   []*Signal
+}
+
+type Signal struct {
+	Name         string
+	Multiplexer  string
+	StartBit     int
+	Length       int
+	LittleEndian bool
+	Signed       bool
+	ValueType    int
+	Factor       float64
+	Offset       float64
+	Minimum      float64
+	Maximum      float64
+	Unit         string
+	Receivers    []string
+}
+
+var (
+	messagePattern   = regexp.MustCompile(`^BO_\s+(\d+)\s+(\w+)\s*:\s*(\d+)\s+(\w+)`)
+	signalPattern    = regexp.MustCompile(`^SG_\s+(\w+)\s*(M|m\d+M?)?\s*:\s*(\d+)\|(\d+)@([01])([+-])\s*\(\s*([^,\s]+)\s*,\s*([^)\s]+)\s*\)\s*\[\s*([^|\s]+)\s*\|\s*([^\]\s]+)\s*\]\s*"((?:[^"\\]|\\.)*)"\s*(.*)$`)
+	valueTypePattern = regexp.MustCompile(`^SIG_VALTYPE_\s+(\d+)\s+(\w+)\s*:?\s*([012])\s*;`)
+	cycleTimePattern = regexp.MustCompile(`^BA_\s+"GenMsgCycleTime"\s+BO_\s+(\d+)\s+(\d+)\s*;`)
+)
+
+// Parses the messages, signals, signal value types and message cycle times
+// of a DBC file, everything else in the file is ignored
+func Parse(reader io.Reader) (*Database, error) {
+	database := &Database{}
+	messages := make(map[int64]*Message)
+	var message *Message
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(text, "BO_ "):
+			match := messagePattern.FindStringSubmatch(text)
+			if match == nil {
+				return nil, lineError(line, "invalid message")
+			}
+			id, err := strconv.ParseInt(match[1], 10, 64)
+			if err != nil {
+				return nil, lineError(line, "invalid message id: "+err.Error())
+			}
+			size, err := strconv.Atoi(match[3])
+			if err != nil {
+				return nil, lineError(line, "invalid message size: "+err.Error())
+			}
+			message = &Message{Id: id &^ extendedIdFlag, Name: match[2], Size: size, Sender: match[4]}
+			messages[id] = message
+			database.Messages = append(database.Messages, message)
+
+		case strings.HasPrefix(text, "SG_ "):
+			match := signalPattern.FindStringSubmatch(text)
+			if match == nil || message == nil {
+				return nil, lineError(line, "invalid signal")
+			}
+			signal, err := parseSignal(match)
+			if err != nil {
+				return nil, lineError(line, err.Error())
+			}
+			message.Signals = append(message.Signals, signal)
+
+		case strings.HasPrefix(text, "SIG_VALTYPE_ "):
+			match := valueTypePattern.FindStringSubmatch(text)
+			if match == nil {
+				return nil, lineError(line, "invalid signal value type")
+			}
+			id, err := strconv.ParseInt(match[1], 10, 64)
+			if err != nil {
+				return nil, lineError(line, "invalid message id: "+err.Error())
+			}
+			valueType, err := strconv.Atoi(match[3])
+			if err != nil {
+				return nil, lineError(line, "invalid signal value type: "+err.Error())
+			}
+			if signal := findSignal(messages[id], match[2]); signal != nil {
+				signal.ValueType = valueType
+			}
+
+		case strings.HasPrefix(text, "BA_ "):
+			if match := cycleTimePattern.FindStringSubmatch(text); match != nil {
+				id, err := strconv.ParseInt(match[1], 10, 64)
+				if err != nil {
+					return nil, lineError(line, "invalid message id: "+err.Error())
+				}
+				cycleTime, err := strconv.Atoi(match[2])
+				if err != nil {
+					return nil, lineError(line, "invalid cycle time: "+err.Error())
+				}
+				if messages[id] != nil {
+					messages[id].CycleTime = cycleTime
+				}
+			}
+
+		case text == "":
+			message = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(database.Messages) == 0 {
+		return nil, errors.New("the file has no messages")
+	}
+	return database, nil
+}
+
+func parseSignal(match []string) (*Signal, error) {
+	signal := &Signal{
+		Name:         match[1],
+		Multiplexer:  match[2],
+		LittleEndian: match[5] == "1",
+		Signed:       match[6] == "-",
+		Unit:         strings.ReplaceAll(match[11], `\"`, `"`),
+	}
+
+	// Attempt to read the position, scaling and range
+	var err error
+	if signal.StartBit, err = strconv.Atoi(match[3]); err != nil {
+		return nil, errors.New("invalid start bit: " + err.Error())
+	}
+	if signal.Length, err = strconv.Atoi(match[4]); err != nil {
+		return nil, errors.New("invalid length: " + err.Error())
+	}
+	numbers := []*float64{&signal.Factor, &signal.Offset, &signal.Minimum, &signal.Maximum}
+	names := []string{"factor", "offset", "minimum", "maximum"}
+	for i, number := range numbers {
+		value, err := strconv.ParseFloat(match[7+i], 64)
+		if err != nil {
+			return nil, errors.New("invalid " + names[i] + ": " + err.Error())
+		}
+		*number = value
+	}
+
+	for _, receiver := range strings.Split(match[12], ",") {
+		if receiver = strings.TrimSpace(receiver); receiver != "" {
+			signal.Receivers = append(signal.Receivers, receiver)
+		}
+	}
+	return signal, nil
+}
+
+func findSignal(message *Message, name string) *Signal {
+	if message == nil {
+		return nil
+	}
+	for _, signal := range message.Signals {
+		if signal.Name == name {
+			return signal
+		}
+	}
+	return nil
+}
+
+func lineError(line int, message string) error {
+	return errors.New("line " + strconv.Itoa(line) + ": " + message)
+}
@@ -0,0 +1,63 @@
+package dbc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		expected string
+	}{
+		{"message id out of range", "BO_ 99999999999999999999 Engine: 8 ECU\n", "line 1: invalid message id"},
+		{"start bit out of range", "BO_ 100 Engine: 8 ECU\n SG_ Rpm : 99999999999999999999|16@1+ (1,0) [0|8000] \"rpm\" Vector__XXX\n", "line 2: invalid start bit"},
+		{"factor", "BO_ 100 Engine: 8 ECU\n SG_ Rpm : 0|16@1+ (x,0) [0|8000] \"rpm\" Vector__XXX\n", "line 2: invalid factor"},
+		{"signal without a message", "SG_ Rpm : 0|16@1+ (1,0) [0|8000] \"rpm\" Vector__XXX\n", "line 1: invalid signal"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(test.file))
+			if err == nil || !strings.HasPrefix(err.Error(), test.expected) {
+				t.Fatalf("expected an error starting with %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestPlanSensorsReportsSkippedSignals(t *testing.T) {
+	file := `BO_ 100 Engine: 8 ECU
+ SG_ Rpm : 0|16@1+ (0.25,0) [0|8000] "rpm" Vector__XXX
+ SG_ Temp : 16|8@0+ (1,0) [0|255] "C" Vector__XXX
+ SG_ Gear : 28|4@1+ (1,0) [0|8] "" Vector__XXX
+ SG_ Oil : 40|8@1+ (1,-40) [-40|215] "C" Vector__XXX
+`
+	database, err := Parse(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := PlanSensors(uuid.New(), nil, database)
+	if len(plan.Create) != 1 || plan.Create[0].Name != "Rpm" || plan.Create[0].ConversionMultiplier != 0.25 {
+		t.Fatalf("expected only Rpm to be created, got %+v", plan.Create)
+	}
+	reasons := map[string]string{}
+	for _, signal := range plan.Unsupported {
+		reasons[signal.Signal] = signal.Reason
+	}
+	expected := map[string]string{
+		"Temp": "big endian signals are not supported",
+		"Gear": "signals must start on a byte",
+		"Oil":  "signals with an offset are not supported",
+	}
+	for name, reason := range expected {
+		if reasons[name] != reason {
+			t.Fatalf("expected %s to be skipped because %q, got %q", name, reason, reasons[name])
+		}
+	}
+	if skipped := plan.Skipped(); !strings.HasPrefix(skipped, "3 unsupported signals") {
+		t.Fatalf("expected the skipped signals to be described, got %q", skipped)
+	}
+}
@@ -0,0 +1,74 @@
+package dbc
+
+import (
+	"database-ms/app/model"
+	"sort"
+	"strconv"
+)
+
+// Schema is a JSON schema of the samples a thing pushes, the counterpart of
+// its DBC file for firmware that sends decoded values. Sensor fields the
+// schema has no keyword for are kept as x- annotations.
+func Schema(thing *model.Thing, sensors []*model.Sensor) map[string]interface{} {
+	sorted := make([]*model.Sensor, len(sensors))
+	copy(sorted, sensors)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].SmallId < sorted[j].SmallId
+	})
+
+	properties := map[string]interface{}{
+		"ts": map[string]interface{}{
+			"type":        "number",
+			"description": "Time of the sample in milliseconds",
+		},
+	}
+	for _, sensor := range sorted {
+		// Derived sensors are computed by the server, not sent by the thing
+		if sensor.IsDerived() {
+			continue
+		}
+		property := map[string]interface{}{
+			"type":                   "number",
+			"title":                  sensor.Name,
+			"x-sensorId":             sensor.Id,
+			"x-type":                 sensor.Type,
+			"x-frequency":            sensor.Frequency,
+			"x-canId":                sensor.CanId,
+			"x-canOffset":            sensor.CanOffset,
+			"x-conversionMultiplier": sensor.ConversionMultiplier,
+			"x-lowerCalibration":     sensor.LowerCalibration,
+			"x-upperCalibration":     sensor.UpperCalibration,
+		}
+		if sensor.Unit != "" {
+			property["x-unit"] = sensor.Unit
+		}
+		if validRange(sensor.LowerBound, sensor.UpperBound) {
+			property["minimum"] = sensor.LowerBound
+			property["maximum"] = sensor.UpperBound
+		}
+		if validRange(sensor.LowerWarning, sensor.UpperWarning) {
+			property["x-lowerWarning"] = sensor.LowerWarning
+			property["x-upperWarning"] = sensor.UpperWarning
+		}
+		if validRange(sensor.LowerDanger, sensor.UpperDanger) {
+			property["x-lowerDanger"] = sensor.LowerDanger
+			property["x-upperDanger"] = sensor.UpperDanger
+		}
+		properties[strconv.Itoa(sensor.SmallId)] = property
+	}
+
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"$id":                  "urn:uuid:" + thing.Id.String(),
+		"title":                thing.Name,
+		"type":                 "object",
+		"properties":           properties,
+		"required":             []string{"ts"},
+		"additionalProperties": false,
+	}
+}
+
+// Checks if a sensor's range is set, unset ranges are stored as zeros
+func validRange(lower float64, upper float64) bool {
+	return upper > lower
+}
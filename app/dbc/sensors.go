@@ -0,0 +1,186 @@
+package dbc
+
+import (
+	"database-ms/app/model"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Highest number of sensors a thing can have, small ids fit in a byte
+const MaxSensors = 256
+
+// SensorPlan is the difference between a DBC file's signals and a thing's
+// sensors. Sensors that are not in the file are left as they are.
+type SensorPlan struct {
+	Create            []*model.Sensor      `json:"create"`
+	Update            []*SensorUpdate      `json:"update"`
+	Unchanged         []string             `json:"unchanged"`
+	Unsupported       []*UnsupportedSignal `json:"unsupported"`
+	AvailableSmallIds int                  `json:"availableSmallIds"`
+}
+
+type SensorUpdate struct {
+	Sensor  *model.Sensor `json:"sensor"`
+	Changes []string      `json:"changes"`
+}
+
+// UnsupportedSignal is a signal the CAN decoder cannot read
+type UnsupportedSignal struct {
+	Message string `json:"message"`
+	Signal  string `json:"signal"`
+	Reason  string `json:"reason"`
+}
+
+// Describes the signals the plan leaves out, empty if there are none
+func (plan *SensorPlan) Skipped() string {
+	if len(plan.Unsupported) == 0 {
+		return ""
+	}
+	return strconv.Itoa(len(plan.Unsupported)) + " unsupported signals are skipped, see unsupported for the reasons"
+}
+
+// Checks if the plan's new sensors can be given small ids
+func (plan *SensorPlan) Fits() bool {
+	return len(plan.Create) <= plan.AvailableSmallIds
+}
+
+// Maps a signal to a sensor. The CAN decoder reads whole little endian values
+// at a byte offset, signals it cannot read return the reason instead.
+func SignalSensor(thingId uuid.UUID, message *Message, signal *Signal) (*model.Sensor, string) {
+	if signal.Multiplexer != "" {
+		return nil, "multiplexed signals are not supported"
+	}
+	if !signal.LittleEndian {
+		return nil, "big endian signals are not supported"
+	}
+	if signal.StartBit%8 != 0 {
+		return nil, "signals must start on a byte"
+	}
+	if signal.Offset != 0 {
+		return nil, "signals with an offset are not supported"
+	}
+
+	sensorType := ""
+	switch {
+	case signal.ValueType == ValueTypeFloat && signal.Length == 32:
+		sensorType = "f"
+	case signal.ValueType == ValueTypeDouble && signal.Length == 64:
+		sensorType = "d"
+	case signal.ValueType == ValueTypeInteger:
+		types := map[int]string{8: "B", 16: "H", 32: "I", 64: "Q"}
+		if signal.Signed {
+			types = map[int]string{8: "b", 16: "h", 32: "i", 64: "q"}
+		}
+		sensorType = types[signal.Length]
+	}
+	if sensorType == "" {
+		return nil, "signals must be 8, 16, 32 or 64 bits long"
+	}
+
+	sensor := &model.Sensor{
+		Type:                 sensorType,
+		Name:                 signal.Name,
+		Unit:                 signal.Unit,
+		CanId:                message.Id,
+		CanOffset:            signal.StartBit / 8,
+		ThingId:              thingId,
+		ConversionMultiplier: signal.Factor,
+		LowerBound:           signal.Minimum,
+		UpperBound:           signal.Maximum,
+	}
+	if message.CycleTime > 0 {
+		sensor.Frequency = int32(1000 / message.CycleTime)
+	}
+	return sensor, ""
+}
+
+// Plans the sensor changes for a DBC file, signals are matched to sensors by name
+func PlanSensors(thingId uuid.UUID, existing []*model.Sensor, database *Database) *SensorPlan {
+	plan := &SensorPlan{
+		Create:            []*model.Sensor{},
+		Update:            []*SensorUpdate{},
+		Unchanged:         []string{},
+		Unsupported:       []*UnsupportedSignal{},
+		AvailableSmallIds: MaxSensors - len(existing),
+	}
+	sensorsByName := make(map[string]*model.Sensor)
+	for _, sensor := range existing {
+		sensorsByName[sensor.Name] = sensor
+	}
+
+	seen := make(map[string]bool)
+	for _, message := range database.Messages {
+		for _, signal := range message.Signals {
+			if seen[signal.Name] {
+				plan.Unsupported = append(plan.Unsupported, &UnsupportedSignal{message.Name, signal.Name, "signal names must be unique"})
+				continue
+			}
+			seen[signal.Name] = true
+
+			sensor, reason := SignalSensor(thingId, message, signal)
+			if sensor == nil {
+				plan.Unsupported = append(plan.Unsupported, &UnsupportedSignal{message.Name, signal.Name, reason})
+				continue
+			}
+			current, ok := sensorsByName[signal.Name]
+			if !ok {
+				plan.Create = append(plan.Create, sensor)
+				continue
+			}
+			if current.IsDerived() {
+				plan.Unsupported = append(plan.Unsupported, &UnsupportedSignal{message.Name, signal.Name, "a derived sensor has the same name"})
+				continue
+			}
+			if update := updateSensor(current, sensor, message.CycleTime > 0); update != nil {
+				plan.Update = append(plan.Update, update)
+			} else {
+				plan.Unchanged = append(plan.Unchanged, signal.Name)
+			}
+		}
+	}
+	return plan
+}
+
+// Applies the fields a DBC file describes to a copy of a sensor, returns nil
+// when nothing changed
+func updateSensor(current *model.Sensor, sensor *model.Sensor, hasFrequency bool) *SensorUpdate {
+	updated := *current
+	changes := []string{}
+	if updated.Type != sensor.Type {
+		updated.Type = sensor.Type
+		changes = append(changes, "type")
+	}
+	if updated.Unit != sensor.Unit {
+		updated.Unit = sensor.Unit
+		changes = append(changes, "unit")
+	}
+	if updated.CanId != sensor.CanId {
+		updated.CanId = sensor.CanId
+		changes = append(changes, "canId")
+	}
+	if updated.CanOffset != sensor.CanOffset {
+		updated.CanOffset = sensor.CanOffset
+		changes = append(changes, "canOffset")
+	}
+	if updated.ConversionMultiplier != sensor.ConversionMultiplier {
+		updated.ConversionMultiplier = sensor.ConversionMultiplier
+		changes = append(changes, "conversionMultiplier")
+	}
+	if updated.LowerBound != sensor.LowerBound {
+		updated.LowerBound = sensor.LowerBound
+		changes = append(changes, "lowerBound")
+	}
+	if updated.UpperBound != sensor.UpperBound {
+		updated.UpperBound = sensor.UpperBound
+		changes = append(changes, "upperBound")
+	}
+	if hasFrequency && updated.Frequency != sensor.Frequency {
+		updated.Frequency = sensor.Frequency
+		changes = append(changes, "frequency")
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return &SensorUpdate{Sensor: &updated, Changes: changes}
+}
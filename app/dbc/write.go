@@ -0,0 +1,144 @@
+package dbc
+
+import (
+	"bufio"
+	"database-ms/app/model"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sender and receiver used when a signal has none
+const noNode = "Vector__XXX"
+
+// Highest standard frame ID, larger IDs are written as extended
+const maxStandardId = 0x7FF
+
+var identifierPattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Writes a thing's sensors as a DBC file with a message per CAN ID. Names
+// that are not valid identifiers are cleaned up and the sensor's name is
+// kept in the signal's comment. Derived sensors are not sent on the bus and
+// are left out.
+func Write(w io.Writer, sensors []*model.Sensor) error {
+	messages := make(map[int64][]*model.Sensor)
+	var canIds []int64
+	for _, sensor := range sensors {
+		if sensor.IsDerived() {
+			continue
+		}
+		if _, ok := messages[sensor.CanId]; !ok {
+			canIds = append(canIds, sensor.CanId)
+		}
+		messages[sensor.CanId] = append(messages[sensor.CanId], sensor)
+	}
+	sort.Slice(canIds, func(i, j int) bool {
+		return canIds[i] < canIds[j]
+	})
+
+	buffered := bufio.NewWriter(w)
+	fmt.Fprint(buffered, "VERSION \"\"\n\nNS_ :\n\tBA_DEF_\n\tBA_DEF_DEF_\n\tBA_\n\tCM_\n\tSIG_VALTYPE_\n\nBS_:\n\nBU_:\n\n")
+
+	var comments, valueTypes, cycleTimes []string
+	for _, canId := range canIds {
+		signals := messages[canId]
+		sort.Slice(signals, func(i, j int) bool {
+			return signals[i].CanOffset < signals[j].CanOffset
+		})
+		messageId := messageId(canId)
+
+		// The message is as long as its furthest signal
+		size := 0
+		frequency := int32(0)
+		for _, sensor := range signals {
+			if end := sensor.CanOffset + sensorSize(sensor); end > size {
+				size = end
+			}
+			if sensor.Frequency > frequency {
+				frequency = sensor.Frequency
+			}
+		}
+		fmt.Fprintf(buffered, "BO_ %d MSG_%X: %d %s\n", messageId, canId, size, noNode)
+
+		for _, sensor := range signals {
+			name := identifier(sensor.Name)
+			sign := "+"
+			if isSigned(sensor.Type) {
+				sign = "-"
+			}
+			factor := sensor.ConversionMultiplier
+			if factor == 0 {
+				factor = 1
+			}
+			fmt.Fprintf(buffered, " SG_ %s : %d|%d@1%s (%s,0) [%s|%s] \"%s\" %s\n",
+				name, 8*sensor.CanOffset, 8*sensorSize(sensor), sign, formatNumber(factor),
+				formatNumber(sensor.LowerBound), formatNumber(sensor.UpperBound),
+				strings.ReplaceAll(sensor.Unit, "\"", "\\\""), noNode)
+
+			if name != sensor.Name {
+				comments = append(comments, fmt.Sprintf("CM_ SG_ %d %s \"%s\";", messageId, name, strings.ReplaceAll(sensor.Name, "\"", "\\\"")))
+			}
+			if sensor.Type == "f" {
+				valueTypes = append(valueTypes, fmt.Sprintf("SIG_VALTYPE_ %d %s : %d;", messageId, name, ValueTypeFloat))
+			} else if sensor.Type == "d" {
+				valueTypes = append(valueTypes, fmt.Sprintf("SIG_VALTYPE_ %d %s : %d;", messageId, name, ValueTypeDouble))
+			}
+		}
+		fmt.Fprint(buffered, "\n")
+
+		if frequency > 0 {
+			cycleTimes = append(cycleTimes, fmt.Sprintf("BA_ \"GenMsgCycleTime\" BO_ %d %d;", messageId, 1000/frequency))
+		}
+	}
+
+	lines := []string{"BA_DEF_ BO_ \"GenMsgCycleTime\" INT 0 65535;", "BA_DEF_DEF_ \"GenMsgCycleTime\" 0;"}
+	lines = append(lines, comments...)
+	lines = append(lines, cycleTimes...)
+	lines = append(lines, valueTypes...)
+	for _, line := range lines {
+		fmt.Fprintln(buffered, line)
+	}
+	return buffered.Flush()
+}
+
+// Sets the extended frame flag on IDs that do not fit a standard frame
+func messageId(canId int64) int64 {
+	if canId > maxStandardId {
+		return canId | extendedIdFlag
+	}
+	return canId
+}
+
+// Size in bytes of a sensor's value, unknown types are read as a byte
+func sensorSize(sensor *model.Sensor) int {
+	switch sensor.Type {
+	case "h", "H":
+		return 2
+	case "i", "I", "f":
+		return 4
+	case "q", "Q", "d":
+		return 8
+	default:
+		return 1
+	}
+}
+
+func isSigned(sensorType string) bool {
+	return sensorType == "b" || sensorType == "h" || sensorType == "i" || sensorType == "q" ||
+		sensorType == "f" || sensorType == "d"
+}
+
+func identifier(name string) string {
+	name = identifierPattern.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,140 @@
+package derived
+
+import (
+	"database-ms/app/model"
+	"errors"
+	"strconv"
+)
+
+// Set computes a thing's derived sensors from the values of its other
+// sensors. Values are keyed by the sensors' small ids, as sent by the things.
+type Set struct {
+	// Derived sensors ordered so that every sensor comes after its inputs
+	sensors []*derivedSensor
+	keys    map[string]*derivedSensor
+}
+
+type derivedSensor struct {
+	key        string
+	expression *Expression
+	inputs     map[string]string
+}
+
+// Compile checks the expressions of the thing's derived sensors, which may
+// only read sensors of the thing and may not depend on themselves
+func Compile(sensors []*model.Sensor) (*Set, error) {
+	byName := make(map[string]*model.Sensor)
+	for _, sensor := range sensors {
+		byName[sensor.Name] = sensor
+	}
+
+	// Attempt to parse every expression and resolve its sensors
+	set := &Set{keys: make(map[string]*derivedSensor)}
+	pending := make(map[string]*derivedSensor)
+	dependencies := make(map[string][]string)
+	var order []string
+	for _, sensor := range sensors {
+		if !sensor.IsDerived() {
+			continue
+		}
+		expression, err := Parse(sensor.Expression)
+		if err != nil {
+			return nil, errors.New(sensor.Name + ": " + err.Error())
+		}
+		derived := &derivedSensor{
+			key:        strconv.Itoa(sensor.SmallId),
+			expression: expression,
+			inputs:     make(map[string]string),
+		}
+		for _, name := range expression.Names() {
+			input, ok := byName[name]
+			if !ok {
+				return nil, errors.New(sensor.Name + ": sensor " + name + " does not exist")
+			}
+			derived.inputs[name] = strconv.Itoa(input.SmallId)
+			if input.IsDerived() {
+				dependencies[sensor.Name] = append(dependencies[sensor.Name], name)
+			}
+		}
+		pending[sensor.Name] = derived
+		order = append(order, sensor.Name)
+		set.keys[derived.key] = derived
+	}
+
+	// Order the sensors after their inputs, rejecting cycles
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return errors.New(name + ": the expression depends on itself")
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dependency := range dependencies[name] {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		set.sensors = append(set.sensors, pending[name])
+		return nil
+	}
+	for _, name := range order {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// IsDerived tells if the small id belongs to a derived sensor
+func (set *Set) IsDerived(key string) bool {
+	return set.keys[key] != nil
+}
+
+// Reads tells if a derived sensor reads any of the values of a sample, so
+// that it is only sampled when one of its inputs is
+func (set *Set) Reads(key string, sample map[string]float64) bool {
+	sensor := set.keys[key]
+	if sensor == nil {
+		return false
+	}
+	for _, input := range sensor.inputs {
+		if _, ok := sample[input]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty tells if the thing has no derived sensors
+func (set *Set) Empty() bool {
+	return len(set.sensors) == 0
+}
+
+// Apply computes the derived sensors from the values and adds them to the
+// values. Derived sensors without a result are removed from the values and
+// the keys of the computed sensors are returned.
+func (set *Set) Apply(values map[string]float64) []string {
+	var computed []string
+	for _, sensor := range set.sensors {
+		value, ok := sensor.expression.Eval(func(name string) (float64, bool) {
+			value, ok := values[sensor.inputs[name]]
+			return value, ok
+		})
+		if !ok {
+			delete(values, sensor.key)
+			continue
+		}
+		values[sensor.key] = value
+		computed = append(computed, sensor.key)
+	}
+	return computed
+}
@@ -0,0 +1,95 @@
+package derived
+
+import (
+	"database-ms/app/model"
+	"reflect"
+	"testing"
+)
+
+func sensor(smallId int, name string, expression string) *model.Sensor {
+	sensor := &model.Sensor{SmallId: smallId, Name: name, Type: "d", Expression: expression}
+	if expression != "" {
+		sensor.Type = model.SensorTypeDerived
+	}
+	return sensor
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		sensors []*model.Sensor
+		err     string
+	}{
+		{"self reference", []*model.Sensor{sensor(1, "a", "a + 1")}, "a: the expression depends on itself"},
+		{"cycle", []*model.Sensor{
+			sensor(1, "raw", ""),
+			sensor(2, "a", "b + raw"),
+			sensor(3, "b", "c * 2"),
+			sensor(4, "c", "a / 2"),
+		}, "a: the expression depends on itself"},
+		{"unknown sensor", []*model.Sensor{sensor(1, "a", "missing * 2")}, "a: sensor missing does not exist"},
+		{"invalid expression", []*model.Sensor{sensor(1, "a", "1 +")}, "a: unexpected end of expression"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Compile(test.sensors)
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestApplyOrdersSensorsAfterTheirInputs(t *testing.T) {
+	// Declared before the derived sensor it reads
+	set, err := Compile([]*model.Sensor{
+		sensor(4, "power", "torque * speed"),
+		sensor(3, "torque", "current / 2"),
+		sensor(1, "current", ""),
+		sensor(2, "speed", ""),
+		sensor(5, "ratio", "speed / current"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set.IsDerived("4") || set.IsDerived("1") {
+		t.Fatal("expected only the derived sensors to be derived")
+	}
+
+	tests := []struct {
+		name     string
+		values   map[string]float64
+		expected map[string]float64
+		computed []string
+	}{
+		{
+			"every input",
+			map[string]float64{"1": 10, "2": 3},
+			map[string]float64{"1": 10, "2": 3, "3": 5, "4": 15, "5": 0.3},
+			[]string{"3", "4", "5"},
+		},
+		{
+			"division by zero",
+			map[string]float64{"1": 0, "2": 3},
+			map[string]float64{"1": 0, "2": 3, "3": 0, "4": 0},
+			[]string{"3", "4"},
+		},
+		{
+			"missing input",
+			map[string]float64{"1": 10, "5": 1},
+			map[string]float64{"1": 10, "3": 5},
+			[]string{"3"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			computed := set.Apply(test.values)
+			if !reflect.DeepEqual(test.values, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, test.values)
+			}
+			if !reflect.DeepEqual(computed, test.computed) {
+				t.Fatalf("expected %v to be computed, got %v", test.computed, computed)
+			}
+		})
+	}
+}
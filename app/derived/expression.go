@@ -0,0 +1,356 @@
+package derived
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed arithmetic expression over sensor names. It supports
+// numbers, + - * / ^, parentheses and the functions below. Sensor names are
+// written as is when they are identifiers, otherwise in double quotes.
+type Expression struct {
+	root  node
+	names []string
+}
+
+type node interface {
+	eval(lookup func(string) (float64, bool)) (float64, bool)
+}
+
+// Functions available in expressions with their number of arguments
+var functions = map[string]struct {
+	arguments int
+	apply     func([]float64) float64
+}{
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"exp":   {1, func(a []float64) float64 { return math.Exp(a[0]) }},
+	"log":   {1, func(a []float64) float64 { return math.Log(a[0]) }},
+	"sin":   {1, func(a []float64) float64 { return math.Sin(a[0]) }},
+	"cos":   {1, func(a []float64) float64 { return math.Cos(a[0]) }},
+	"atan2": {2, func(a []float64) float64 { return math.Atan2(a[0], a[1]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+}
+
+// Parse reads an expression, it is not checked against any sensors
+func Parse(source string) (*Expression, error) {
+	p := &parser{source: source}
+	p.next()
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.token.kind != tokenEnd {
+		return nil, p.unexpected()
+	}
+	return &Expression{root: root, names: p.names}, nil
+}
+
+// Names lists the sensors the expression reads, each once
+func (expression *Expression) Names() []string {
+	return expression.names
+}
+
+// Eval computes the expression with the sensor values found by lookup. There
+// is no result when a sensor has no value or the result is not a number.
+func (expression *Expression) Eval(lookup func(string) (float64, bool)) (float64, bool) {
+	value, ok := expression.root.eval(lookup)
+	if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
+}
+
+type number float64
+
+func (n number) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	return float64(n), true
+}
+
+type variable string
+
+func (v variable) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	return lookup(string(v))
+}
+
+type negate struct {
+	operand node
+}
+
+func (n *negate) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	value, ok := n.operand.eval(lookup)
+	return -value, ok
+}
+
+type binary struct {
+	operator    byte
+	left, right node
+}
+
+func (b *binary) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	left, ok := b.left.eval(lookup)
+	if !ok {
+		return 0, false
+	}
+	right, ok := b.right.eval(lookup)
+	if !ok {
+		return 0, false
+	}
+	switch b.operator {
+	case '+':
+		return left + right, true
+	case '-':
+		return left - right, true
+	case '*':
+		return left * right, true
+	case '/':
+		return left / right, true
+	default:
+		return math.Pow(left, right), true
+	}
+}
+
+type call struct {
+	apply     func([]float64) float64
+	arguments []node
+}
+
+func (c *call) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	values := make([]float64, len(c.arguments))
+	for i, argument := range c.arguments {
+		value, ok := argument.eval(lookup)
+		if !ok {
+			return 0, false
+		}
+		values[i] = value
+	}
+	return c.apply(values), true
+}
+
+const (
+	tokenEnd = iota
+	tokenNumber
+	tokenName
+	tokenQuoted
+	tokenSymbol
+	tokenInvalid
+)
+
+type token struct {
+	kind     int
+	text     string
+	position int
+}
+
+// Recursive descent parser, one token of lookahead
+type parser struct {
+	source   string
+	position int
+	token    token
+	names    []string
+}
+
+func (p *parser) next() {
+	for p.position < len(p.source) && unicode.IsSpace(rune(p.source[p.position])) {
+		p.position++
+	}
+	start := p.position
+	if p.position >= len(p.source) {
+		p.token = token{kind: tokenEnd, position: start}
+		return
+	}
+	c := p.source[p.position]
+	switch {
+	case c >= '0' && c <= '9' || c == '.':
+		for p.position < len(p.source) && isNumberByte(p.source, p.position) {
+			p.position++
+		}
+		p.token = token{kind: tokenNumber, text: p.source[start:p.position], position: start}
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.position < len(p.source) && (p.source[p.position] == '_' ||
+			unicode.IsLetter(rune(p.source[p.position])) || unicode.IsDigit(rune(p.source[p.position]))) {
+			p.position++
+		}
+		p.token = token{kind: tokenName, text: p.source[start:p.position], position: start}
+	case c == '"':
+		end := strings.IndexByte(p.source[start+1:], '"')
+		if end < 0 {
+			p.token = token{kind: tokenInvalid, text: p.source[start:], position: start}
+			p.position = len(p.source)
+			return
+		}
+		p.position = start + end + 2
+		p.token = token{kind: tokenQuoted, text: p.source[start+1 : start+end+1], position: start}
+	case strings.IndexByte("+-*/^(),", c) >= 0:
+		p.position++
+		p.token = token{kind: tokenSymbol, text: string(c), position: start}
+	default:
+		p.position++
+		p.token = token{kind: tokenInvalid, text: string(c), position: start}
+	}
+}
+
+// Numbers may have a fraction and an exponent such as 1.5e-3
+func isNumberByte(source string, i int) bool {
+	c := source[i]
+	if c >= '0' && c <= '9' || c == '.' || c == 'e' || c == 'E' {
+		return true
+	}
+	return (c == '+' || c == '-') && i > 0 && (source[i-1] == 'e' || source[i-1] == 'E')
+}
+
+func (p *parser) unexpected() error {
+	if p.token.kind == tokenEnd {
+		return errors.New("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at position %d", p.token.text, p.token.position+1)
+}
+
+func (p *parser) isSymbol(symbol string) bool {
+	return p.token.kind == tokenSymbol && p.token.text == symbol
+}
+
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.isSymbol("+") || p.isSymbol("-") {
+		operator := p.token.text[0]
+		p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: operator, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isSymbol("*") || p.isSymbol("/") {
+		operator := p.token.text[0]
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: operator, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isSymbol("-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negate{operand: operand}, nil
+	}
+	if p.isSymbol("+") {
+		p.next()
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+// Powers are right associative and bind tighter than a leading minus
+func (p *parser) parsePower() (node, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.isSymbol("^") {
+		p.next()
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &binary{operator: '^', left: base, right: exponent}, nil
+	}
+	return base, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	current := p.token
+	switch current.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(current.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", current.text, current.position+1)
+		}
+		p.next()
+		return number(value), nil
+	case tokenQuoted:
+		p.next()
+		return p.variable(current.text), nil
+	case tokenName:
+		p.next()
+		if !p.isSymbol("(") {
+			return p.variable(current.text), nil
+		}
+		return p.parseCall(current)
+	case tokenSymbol:
+		if current.text == "(" {
+			p.next()
+			inner, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isSymbol(")") {
+				return nil, p.unexpected()
+			}
+			p.next()
+			return inner, nil
+		}
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	function, ok := functions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.position+1)
+	}
+	p.next()
+	var arguments []node
+	for !p.isSymbol(")") {
+		if len(arguments) > 0 {
+			if !p.isSymbol(",") {
+				return nil, p.unexpected()
+			}
+			p.next()
+		}
+		argument, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argument)
+	}
+	p.next()
+	if len(arguments) != function.arguments {
+		return nil, fmt.Errorf("%s takes %d arguments", name.text, function.arguments)
+	}
+	return &call{apply: function.apply, arguments: arguments}, nil
+}
+
+func (p *parser) variable(name string) node {
+	for _, existing := range p.names {
+		if existing == name {
+			return variable(name)
+		}
+	}
+	p.names = append(p.names, name)
+	return variable(name)
+}
@@ -0,0 +1,94 @@
+package derived
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	values := map[string]float64{"rpm": 120, "wheel speed": 3, "a": 2, "b": -5}
+	lookup := func(name string) (float64, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		expected float64
+		ok       bool
+	}{
+		{"product before sum", "1 + 2 * 3", 7, true},
+		{"parentheses", "(1 + 2) * 3", 9, true},
+		{"left associative difference", "10 - 4 - 3", 3, true},
+		{"left associative quotient", "8 / 4 / 2", 1, true},
+		{"right associative power", "2 ^ 3 ^ 2", 512, true},
+		{"power before product", "2 * 3 ^ 2", 18, true},
+		{"power before unary minus", "-2 ^ 2", -4, true},
+		{"negative exponent", "2 ^ -1", 0.5, true},
+		{"unary minus after operator", "2 * -3", -6, true},
+		{"double unary minus", "--3", 3, true},
+		{"unary plus", "+3 - -3", 6, true},
+		{"negated parentheses", "-(1 + 2) * 2", -6, true},
+		{"exponent notation", "1.5e-3 * 1000", 1.5, true},
+		{"sensor", "rpm / 60", 2, true},
+		{"quoted sensor", `"wheel speed" * 2`, 6, true},
+		{"functions", "max(a, abs(b)) + min(1, 2)", 6, true},
+		{"function names ignore case", "SQRT(16)", 4, true},
+		{"division by zero", "1 / 0", 0, false},
+		{"zero by zero", "0 / 0", 0, false},
+		{"division by a zero sensor", "rpm / (a - 2)", 0, false},
+		{"not a number", "sqrt(-1)", 0, false},
+		{"missing sensor", "missing + 1", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expression, err := Parse(test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, ok := expression.Eval(lookup)
+			if ok != test.ok || value != test.expected {
+				t.Fatalf("expected %v (%v), got %v (%v)", test.expected, test.ok, value, ok)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", "unexpected end of expression"},
+		{"1 2", `unexpected "2" at position 3`},
+		{"1 + * 2", `unexpected "*" at position 5`},
+		{"1 $ 2", `unexpected "$" at position 3`},
+		{`"open + 1`, `unexpected "\"open + 1" at position 1`},
+		{"1..2", `invalid number "1..2" at position 1`},
+		{"foo(1)", `unknown function "foo" at position 1`},
+		{"max(1)", "max takes 2 arguments"},
+		{"max(1 2)", `unexpected "2" at position 7`},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			_, err := Parse(test.source)
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestNames(t *testing.T) {
+	expression, err := Parse(`a + a * "b c" - max(b, a)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := expression.Names(); !reflect.DeepEqual(names, []string{"a", "b c", "b"}) {
+		t.Fatalf("expected each sensor once, got %s", strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,41 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// Writes the table like the session's stored csv, a timestamp column followed
+// by a column per sensor that is empty where the sensor has no value
+func WriteCsv(w io.Writer, table *Table) error {
+	buffered := bufio.NewWriter(w)
+	writer := csv.NewWriter(buffered)
+	header := []string{"Timestamp"}
+	for _, sensor := range table.Sensors {
+		header = append(header, sensor.Name)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	row := make([]string, len(table.Sensors)+1)
+	for i, timestamp := range table.Timestamps {
+		row[0] = strconv.FormatInt(timestamp, 10)
+		for j, column := range table.Columns {
+			row[j+1] = ""
+			if column[i] != nil {
+				row[j+1] = strconv.FormatFloat(*column[i], 'f', -1, 64)
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
@@ -0,0 +1,97 @@
+package export
+
+import (
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"io"
+	"sort"
+)
+
+const (
+	FormatCsv     = "csv"
+	FormatParquet = "parquet"
+	FormatMdf4    = "mdf4"
+	FormatMotec   = "motec"
+)
+
+// Format describes how a session is written in a download format
+type Format struct {
+	ContentType string
+	Extension   string
+	Write       func(io.Writer, *Table) error
+}
+
+var formats = map[string]Format{
+	FormatCsv:     {ContentType: "text/csv", Extension: ".csv", Write: WriteCsv},
+	FormatParquet: {ContentType: "application/vnd.apache.parquet", Extension: ".parquet", Write: WriteParquet},
+	FormatMdf4:    {ContentType: "application/octet-stream", Extension: ".mf4", Write: WriteMdf4},
+	FormatMotec:   {ContentType: "text/csv", Extension: ".csv", Write: WriteMotecCsv},
+}
+
+// Finds a generated format, a csv is only generated when the stored file
+// cannot be sent as is
+func FindFormat(name string) (Format, bool) {
+	format, ok := formats[name]
+	return format, ok
+}
+
+// Table is a session's data on a shared timestamp axis, with a column per
+// sensor that is nil where the sensor has no value
+type Table struct {
+	Session    *model.Session
+	Thing      *model.Thing
+	Sensors    []*model.Sensor
+	Timestamps []int64
+	Columns    [][]*float64
+}
+
+func NewTable(session *model.Session, thing *model.Thing, sensors []*model.Sensor, aligned *services.AlignedData) *Table {
+	sorted := make([]*model.Sensor, len(sensors))
+	copy(sorted, sensors)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	table := &Table{
+		Session:    session,
+		Thing:      thing,
+		Sensors:    sorted,
+		Timestamps: aligned.Timestamps,
+		Columns:    make([][]*float64, len(sorted)),
+	}
+	for i, sensor := range sorted {
+		table.Columns[i] = aligned.Columns[sensor.Id]
+		if table.Columns[i] == nil {
+			table.Columns[i] = make([]*float64, len(aligned.Timestamps))
+		}
+	}
+	return table
+}
+
+// StartTime is when the first sample was taken. Timestamps before the start
+// of the session are relative to the thing's clock so the session's start is
+// used instead.
+func (t *Table) StartTime() int64 {
+	if len(t.Timestamps) == 0 || t.Timestamps[0] < t.Session.StartTime {
+		return t.Session.StartTime
+	}
+	return t.Timestamps[0]
+}
+
+// Seconds since the first sample for each timestamp
+func (t *Table) offset(i int) float64 {
+	return float64(t.Timestamps[i]-t.Timestamps[0]) / 1000
+}
+
+// Duration of the data in seconds
+func (t *Table) duration() float64 {
+	if len(t.Timestamps) == 0 {
+		return 0
+	}
+	return t.offset(len(t.Timestamps) - 1)
+}
+
+// Checks if a sensor's range is set, unset ranges are stored as zeros
+func validRange(lower float64, upper float64) bool {
+	return upper > lower
+}
@@ -0,0 +1,34 @@
+package export
+
+import (
+	"database-ms/app/model"
+
+	"github.com/google/uuid"
+)
+
+func float(value float64) *float64 {
+	return &value
+}
+
+// Builds a table with two calibrated sensors and a few missing values
+func testTable() *Table {
+	session := &model.Session{Name: "Endurance <1>", StartTime: 1000}
+	session.Id = uuid.MustParse("7c9e6679-7425-40de-944b-e07fc1f90ae7")
+	thing := &model.Thing{Name: "Car"}
+	sensors := []*model.Sensor{
+		{Name: "rpm", SmallId: 1, Type: "d", Unit: "1/min", LowerCalibration: 0.5, UpperCalibration: 4.5, ConversionMultiplier: 2, LowerBound: 0, UpperBound: 12000},
+		{Name: "speed", SmallId: 2, Type: "d", Unit: "km/h", ConversionMultiplier: 0.25},
+	}
+	sensors[0].Id = uuid.MustParse("0b9e3f1c-5a2d-4c1e-9f3a-1d2e3f4a5b6c")
+	sensors[1].Id = uuid.MustParse("5f0c2a7e-8b1d-4e3f-a6c9-2b4d6e8f0a1c")
+	return &Table{
+		Session:    session,
+		Thing:      thing,
+		Sensors:    sensors,
+		Timestamps: []int64{1000, 1010, 1020, 1030, 1040},
+		Columns: [][]*float64{
+			{float(800), nil, nil, float(950.5), float(1200)},
+			{nil, float(12.25), float(13), float(14), nil},
+		},
+	}
+}
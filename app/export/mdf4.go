@@ -0,0 +1,276 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// MDF4 channel enum values and flags used by the writer
+const (
+	mdfChannelValue       = 0
+	mdfChannelMaster      = 2
+	mdfSyncNone           = 0
+	mdfSyncTime           = 1
+	mdfFloatLittleEndian  = 5
+	mdfInvalBitValid      = 0x02
+	mdfValueRangeValid    = 0x08
+	mdfLimitRangeValid    = 0x10
+	mdfExtLimitRangeValid = 0x20
+)
+
+// Time of the file's history entry, replaced by tests for files that do not change
+var mdfNow = time.Now
+
+type mdfBlock struct {
+	id     string
+	offset int64
+	links  []int64
+	data   []byte
+}
+
+// mdfFile lays out the blocks of an MDF4 file after its 64 byte id block,
+// every block is aligned to 8 bytes
+type mdfFile struct {
+	blocks []*mdfBlock
+	offset int64
+}
+
+type mdfHeaderData struct {
+	StartTimeNs   uint64
+	TzOffsetMin   int16
+	DstOffsetMin  int16
+	TimeFlags     uint8
+	TimeClass     uint8
+	Flags         uint8
+	Reserved      uint8
+	StartAngle    float64
+	StartDistance float64
+}
+
+type mdfHistoryData struct {
+	TimeNs       uint64
+	TzOffsetMin  int16
+	DstOffsetMin int16
+	TimeFlags    uint8
+	Reserved     [3]byte
+}
+
+type mdfDataGroupData struct {
+	RecordIdSize uint8
+	Reserved     [7]byte
+}
+
+type mdfChannelGroupData struct {
+	RecordId      uint64
+	CycleCount    uint64
+	Flags         uint16
+	PathSeparator uint16
+	Reserved      [4]byte
+	DataBytes     uint32
+	InvalBytes    uint32
+}
+
+type mdfChannelData struct {
+	Type            uint8
+	SyncType        uint8
+	DataType        uint8
+	BitOffset       uint8
+	ByteOffset      uint32
+	BitCount        uint32
+	Flags           uint32
+	InvalBitPos     uint32
+	Precision       uint8
+	Reserved        uint8
+	AttachmentCount uint16
+	ValueRangeMin   float64
+	ValueRangeMax   float64
+	LimitMin        float64
+	LimitMax        float64
+	LimitExtMin     float64
+	LimitExtMax     float64
+}
+
+func (m *mdfFile) add(id string, linkCount int, data []byte) *mdfBlock {
+	for len(data)%8 != 0 {
+		data = append(data, 0)
+	}
+	block := &mdfBlock{id: id, offset: m.offset, links: make([]int64, linkCount), data: data}
+	m.offset += int64(24 + 8*linkCount + len(data))
+	m.blocks = append(m.blocks, block)
+	return block
+}
+
+func (m *mdfFile) addStruct(id string, linkCount int, data interface{}) *mdfBlock {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, data)
+	return m.add(id, linkCount, buf.Bytes())
+}
+
+// Adds a zero terminated text block, empty text is not written
+func (m *mdfFile) addText(id string, text string) int64 {
+	if text == "" {
+		return 0
+	}
+	return m.add(id, 0, append([]byte(text), 0)).offset
+}
+
+func writeMdfBlockHeader(w io.Writer, id string, length int64, linkCount int) error {
+	header := make([]byte, 24)
+	copy(header, id)
+	binary.LittleEndian.PutUint64(header[8:], uint64(length))
+	binary.LittleEndian.PutUint64(header[16:], uint64(linkCount))
+	_, err := w.Write(header)
+	return err
+}
+
+// Writes the table as an ASAM MDF 4.10 file with a single channel group. The
+// master channel is the time in seconds since the first sample, values that
+// are missing are marked with invalidation bits. Units are written with each
+// channel, the sensor bounds, warning and danger thresholds as its value,
+// limit and extended limit ranges and the rest of the sensor metadata as
+// common properties of its comment.
+func WriteMdf4(w io.Writer, table *Table) error {
+	file := &mdfFile{offset: 64}
+	inval := (len(table.Sensors) + 7) / 8
+	recordSize := 8*(len(table.Sensors)+1) + inval
+
+	// File header and history
+	header := file.addStruct("##HD", 6, mdfHeaderData{StartTimeNs: uint64(table.StartTime()) * uint64(time.Millisecond)})
+	header.links[5] = file.addText("##MD", "<HDcomment xmlns=\"http://www.asam.net/mdf/v4\"><TX>"+
+		escapeXml(table.Session.Name)+"</TX><common_properties>"+
+		mdfProperty("sessionId", table.Session.Id.String())+
+		mdfProperty("vehicle", table.Thing.Name)+
+		"</common_properties></HDcomment>")
+	history := file.addStruct("##FH", 2, mdfHistoryData{TimeNs: uint64(mdfNow().UnixNano())})
+	history.links[1] = file.addText("##MD", "<FHcomment xmlns=\"http://www.asam.net/mdf/v4\"><TX>Exported from the datum table</TX>"+
+		"<tool_id>SRVRelayMS</tool_id><tool_vendor>Schulich Racing</tool_vendor><tool_version>1</tool_version></FHcomment>")
+	header.links[1] = history.offset
+
+	// A single data group and channel group holding every record
+	dataGroup := file.addStruct("##DG", 4, mdfDataGroupData{})
+	header.links[0] = dataGroup.offset
+	channelGroup := file.addStruct("##CG", 6, mdfChannelGroupData{
+		CycleCount: uint64(len(table.Timestamps)),
+		DataBytes:  uint32(8 * (len(table.Sensors) + 1)),
+		InvalBytes: uint32(inval),
+	})
+	dataGroup.links[1] = channelGroup.offset
+
+	// The master channel is followed by a channel per sensor
+	master := file.addStruct("##CN", 8, mdfChannelData{
+		Type:     mdfChannelMaster,
+		SyncType: mdfSyncTime,
+		DataType: mdfFloatLittleEndian,
+		BitCount: 64,
+	})
+	master.links[2] = file.addText("##TX", "time")
+	master.links[6] = file.addText("##TX", "s")
+	channelGroup.links[1] = master.offset
+	previous := master
+	for i, sensor := range table.Sensors {
+		data := mdfChannelData{
+			Type:        mdfChannelValue,
+			SyncType:    mdfSyncNone,
+			DataType:    mdfFloatLittleEndian,
+			ByteOffset:  uint32(8 * (i + 1)),
+			BitCount:    64,
+			Flags:       mdfInvalBitValid,
+			InvalBitPos: uint32(i),
+		}
+		if validRange(sensor.LowerBound, sensor.UpperBound) {
+			data.Flags |= mdfValueRangeValid
+			data.ValueRangeMin, data.ValueRangeMax = sensor.LowerBound, sensor.UpperBound
+		}
+		if validRange(sensor.LowerWarning, sensor.UpperWarning) {
+			data.Flags |= mdfLimitRangeValid
+			data.LimitMin, data.LimitMax = sensor.LowerWarning, sensor.UpperWarning
+			if validRange(sensor.LowerDanger, sensor.UpperDanger) {
+				data.Flags |= mdfExtLimitRangeValid
+				data.LimitExtMin, data.LimitExtMax = sensor.LowerDanger, sensor.UpperDanger
+			}
+		}
+		channel := file.addStruct("##CN", 8, data)
+		channel.links[2] = file.addText("##TX", sensor.Name)
+		channel.links[6] = file.addText("##TX", sensor.Unit)
+		channel.links[7] = file.addText("##MD", "<CNcomment xmlns=\"http://www.asam.net/mdf/v4\"><TX>"+
+			escapeXml(sensor.Name)+"</TX><common_properties>"+
+			mdfProperty("sensorId", sensor.Id.String())+
+			mdfProperty("smallId", strconv.Itoa(sensor.SmallId))+
+			mdfProperty("type", sensor.Type)+
+			mdfProperty("frequency", strconv.Itoa(int(sensor.Frequency)))+
+			mdfProperty("canId", strconv.FormatInt(sensor.CanId, 10))+
+			mdfProperty("canOffset", strconv.Itoa(sensor.CanOffset))+
+			mdfProperty("lowerCalibration", formatFloat(sensor.LowerCalibration))+
+			mdfProperty("upperCalibration", formatFloat(sensor.UpperCalibration))+
+			mdfProperty("conversionMultiplier", formatFloat(sensor.ConversionMultiplier))+
+			"</common_properties></CNcomment>")
+		previous.links[0] = channel.offset
+		previous = channel
+	}
+	dataGroup.links[2] = file.offset
+
+	// Attempt to write the id block and the blocks laid out so far
+	buffered := bufio.NewWriter(w)
+	id := make([]byte, 64)
+	copy(id, "MDF     4.10    SRVRelay")
+	binary.LittleEndian.PutUint16(id[28:], 410)
+	if _, err := buffered.Write(id); err != nil {
+		return err
+	}
+	for _, block := range file.blocks {
+		if err := writeMdfBlockHeader(buffered, block.id, int64(24+8*len(block.links)+len(block.data)), len(block.links)); err != nil {
+			return err
+		}
+		if err := binary.Write(buffered, binary.LittleEndian, block.links); err != nil {
+			return err
+		}
+		if _, err := buffered.Write(block.data); err != nil {
+			return err
+		}
+	}
+
+	// Attempt to write the records, the data block comes last so it can be streamed
+	if err := writeMdfBlockHeader(buffered, "##DT", int64(24+recordSize*len(table.Timestamps)), 0); err != nil {
+		return err
+	}
+	record := make([]byte, recordSize)
+	for row := range table.Timestamps {
+		binary.LittleEndian.PutUint64(record, math.Float64bits(table.offset(row)))
+		for i := range record[recordSize-inval:] {
+			record[recordSize-inval+i] = 0
+		}
+		for i, column := range table.Columns {
+			value := 0.0
+			if column[row] != nil {
+				value = *column[row]
+			} else {
+				record[recordSize-inval+i/8] |= 1 << (i % 8)
+			}
+			binary.LittleEndian.PutUint64(record[8*(i+1):], math.Float64bits(value))
+		}
+		if _, err := buffered.Write(record); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+func mdfProperty(name string, value string) string {
+	return "<e name=\"" + escapeXml(name) + "\">" + escapeXml(value) + "</e>"
+}
+
+func escapeXml(text string) string {
+	buf := &bytes.Buffer{}
+	xml.EscapeText(buf, []byte(text))
+	return buf.String()
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,209 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+type mdfTestBlock struct {
+	id    string
+	links []int64
+	data  []byte
+}
+
+// Reads the block at an offset, checking its header against the file
+func readMdfBlock(t *testing.T, file []byte, offset int64, id string) *mdfTestBlock {
+	t.Helper()
+	if offset <= 0 || offset%8 != 0 || offset+24 > int64(len(file)) {
+		t.Fatalf("%s link %d is not a block offset", id, offset)
+	}
+	header := file[offset : offset+24]
+	if string(header[:4]) != id {
+		t.Fatalf("block at %d is %q, expected %s", offset, header[:4], id)
+	}
+	length := int64(binary.LittleEndian.Uint64(header[8:]))
+	linkCount := int64(binary.LittleEndian.Uint64(header[16:]))
+	if length < 24+8*linkCount || offset+length > int64(len(file)) {
+		t.Fatalf("%s at %d has length %d with %d links", id, offset, length, linkCount)
+	}
+	block := &mdfTestBlock{id: id, links: make([]int64, linkCount)}
+	for i := range block.links {
+		block.links[i] = int64(binary.LittleEndian.Uint64(file[offset+24+8*int64(i):]))
+	}
+	block.data = file[offset+24+8*linkCount : offset+length]
+	return block
+}
+
+func readMdfText(t *testing.T, file []byte, offset int64, id string) string {
+	t.Helper()
+	block := readMdfBlock(t, file, offset, id)
+	return string(block.data[:bytes.IndexByte(block.data, 0)])
+}
+
+type mdfComment struct {
+	Text       string `xml:"TX"`
+	Properties []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"common_properties>e"`
+}
+
+func TestWriteMdf4Blocks(t *testing.T) {
+	table := testTable()
+	buf := &bytes.Buffer{}
+	if err := WriteMdf4(buf, table); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	// Id block
+	if !bytes.HasPrefix(file, []byte("MDF     4.10    ")) || binary.LittleEndian.Uint16(file[28:]) != 410 {
+		t.Fatalf("invalid id block %q", file[:32])
+	}
+
+	// Every block follows the previous one, the data block comes last
+	for offset := int64(64); offset < int64(len(file)); {
+		length := int64(binary.LittleEndian.Uint64(file[offset+8:]))
+		if length%8 != 0 && string(file[offset:offset+4]) != "##DT" {
+			t.Errorf("block at %d has unaligned length %d", offset, length)
+		}
+		offset += length
+		if offset > int64(len(file)) {
+			t.Fatalf("block ends at %d past the end of the file", offset)
+		}
+	}
+
+	header := readMdfBlock(t, file, 64, "##HD")
+	if len(header.links) != 6 {
+		t.Fatalf("HD has %d links, expected 6", len(header.links))
+	}
+	if startTime := binary.LittleEndian.Uint64(header.data); startTime != uint64(table.StartTime())*1000000 {
+		t.Errorf("start time is %d, expected %d", startTime, table.StartTime()*1000000)
+	}
+	readMdfBlock(t, file, header.links[1], "##FH")
+	sessionComment := readMdfText(t, file, header.links[5], "##MD")
+	if !strings.Contains(sessionComment, "Endurance &lt;1&gt;") {
+		t.Errorf("session name is not escaped in %s", sessionComment)
+	}
+
+	dataGroup := readMdfBlock(t, file, header.links[0], "##DG")
+	channelGroup := readMdfBlock(t, file, dataGroup.links[1], "##CG")
+	if cycles := binary.LittleEndian.Uint64(channelGroup.data[8:]); cycles != uint64(len(table.Timestamps)) {
+		t.Errorf("cycle count is %d, expected %d", cycles, len(table.Timestamps))
+	}
+	dataBytes := int(binary.LittleEndian.Uint32(channelGroup.data[24:]))
+	invalBytes := int(binary.LittleEndian.Uint32(channelGroup.data[28:]))
+	recordSize := dataBytes + invalBytes
+	if dataBytes != 8*(len(table.Sensors)+1) || invalBytes != 1 {
+		t.Errorf("records have %d data and %d invalidation bytes", dataBytes, invalBytes)
+	}
+
+	// The records fill the data block to the end of the file
+	data := readMdfBlock(t, file, dataGroup.links[2], "##DT")
+	if dataGroup.links[2]+24+int64(len(data.data)) != int64(len(file)) {
+		t.Errorf("data block does not end the file")
+	}
+	if len(data.data) != recordSize*len(table.Timestamps) {
+		t.Fatalf("data block has %d bytes, expected %d", len(data.data), recordSize*len(table.Timestamps))
+	}
+
+	// Master channel followed by a channel per sensor
+	master := readMdfBlock(t, file, channelGroup.links[1], "##CN")
+	if master.data[0] != mdfChannelMaster || readMdfText(t, file, master.links[2], "##TX") != "time" {
+		t.Errorf("first channel is not the time master")
+	}
+	channel := master
+	for i, sensor := range table.Sensors {
+		channel = readMdfBlock(t, file, channel.links[0], "##CN")
+		if name := readMdfText(t, file, channel.links[2], "##TX"); name != sensor.Name {
+			t.Errorf("channel %d is %s, expected %s", i, name, sensor.Name)
+		}
+		if unit := readMdfText(t, file, channel.links[6], "##TX"); unit != sensor.Unit {
+			t.Errorf("%s unit is %s, expected %s", sensor.Name, unit, sensor.Unit)
+		}
+		byteOffset := int(binary.LittleEndian.Uint32(channel.data[4:]))
+		invalBitPos := int(binary.LittleEndian.Uint32(channel.data[16:]))
+
+		// Calibration is kept with the rest of the sensor's metadata
+		comment := mdfComment{}
+		if err := xml.Unmarshal([]byte(readMdfText(t, file, channel.links[7], "##MD")), &comment); err != nil {
+			t.Fatal(err)
+		}
+		properties := map[string]string{}
+		for _, property := range comment.Properties {
+			properties[property.Name] = property.Value
+		}
+		expected := map[string]string{
+			"sensorId":             sensor.Id.String(),
+			"lowerCalibration":     formatFloat(sensor.LowerCalibration),
+			"upperCalibration":     formatFloat(sensor.UpperCalibration),
+			"conversionMultiplier": formatFloat(sensor.ConversionMultiplier),
+		}
+		for name, value := range expected {
+			if properties[name] != value {
+				t.Errorf("%s %s is %q, expected %q", sensor.Name, name, properties[name], value)
+			}
+		}
+
+		// Values and invalidation bits of each record
+		for row, want := range table.Columns[i] {
+			record := data.data[row*recordSize : (row+1)*recordSize]
+			invalid := record[dataBytes+invalBitPos/8]&(1<<(invalBitPos%8)) != 0
+			value := math.Float64frombits(binary.LittleEndian.Uint64(record[byteOffset:]))
+			if invalid != (want == nil) || want != nil && value != *want {
+				t.Errorf("%s row %d is %v (invalid %v), expected %v", sensor.Name, row, value, invalid, want)
+			}
+		}
+	}
+	if channel.links[0] != 0 {
+		t.Errorf("last channel links to %d", channel.links[0])
+	}
+
+	// Master values are seconds since the first sample
+	for row := range table.Timestamps {
+		seconds := math.Float64frombits(binary.LittleEndian.Uint64(data.data[row*recordSize:]))
+		if seconds != table.offset(row) {
+			t.Errorf("row %d is at %vs, expected %vs", row, seconds, table.offset(row))
+		}
+	}
+}
+
+// Rewrite the golden file with go test -update after changing the format and
+// check that the new file still opens in asammdf before committing it
+func TestWriteMdf4Golden(t *testing.T) {
+	mdfNow = func() time.Time { return time.Unix(1700000000, 0) }
+	defer func() { mdfNow = time.Now }()
+
+	buf := &bytes.Buffer{}
+	if err := WriteMdf4(buf, testTable()); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join("testdata", "session.mf4")
+	if *update {
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		for i := range golden {
+			if i >= buf.Len() || buf.Bytes()[i] != golden[i] {
+				t.Fatalf("the file differs from %s at byte %d, %d bytes written for %d", path, i, buf.Len(), len(golden))
+			}
+		}
+		t.Fatalf("the file has %d bytes past the end of %s", buf.Len()-len(golden), path)
+	}
+}
@@ -0,0 +1,120 @@
+package export
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample rate used when none of the sensors have a frequency, and the
+// highest rate a MoTeC csv is resampled to
+const (
+	motecDefaultRate = 10
+	motecMaxRate     = 1000
+)
+
+// Writes the table as a csv that MoTeC i2 can import. i2 expects a fixed
+// sample rate so the data is resampled at the fastest sensor's frequency,
+// each value is held until the next one and values before a sensor's first
+// sample take that sample's value.
+func WriteMotecCsv(w io.Writer, table *Table) error {
+	rate := int32(0)
+	for _, sensor := range table.Sensors {
+		if sensor.Frequency > rate {
+			rate = sensor.Frequency
+		}
+	}
+	if rate <= 0 {
+		rate = motecDefaultRate
+	} else if rate > motecMaxRate {
+		rate = motecMaxRate
+	}
+	rows := int(math.Floor(table.duration()*float64(rate))) + 1
+	if len(table.Timestamps) == 0 {
+		rows = 0
+	}
+	duration := float64(0)
+	if rows > 0 {
+		duration = float64(rows-1) / float64(rate)
+	}
+
+	// Header rows, followed by the channel names and units
+	start := time.UnixMilli(table.StartTime()).UTC()
+	lines := [][]string{
+		{"Format", "MoTeC CSV File"},
+		{"Venue", ""},
+		{"Vehicle", table.Thing.Name},
+		{"Driver", ""},
+		{"Device", "SRVRelayMS"},
+		{"Comment", table.Session.Name},
+		{"Log Date", start.Format("02/01/2006")},
+		{"Log Time", start.Format("15:04:05")},
+		{"Sample Rate", strconv.Itoa(int(rate)), "Hz"},
+		{"Duration", strconv.FormatFloat(duration, 'f', 3, 64), "s"},
+		{"Range", "entire outing"},
+		{"Beacon Markers"},
+		{},
+		{},
+	}
+	names := []string{"Time"}
+	units := []string{"s"}
+	for _, sensor := range table.Sensors {
+		names = append(names, sensor.Name)
+		units = append(units, sensor.Unit)
+	}
+	lines = append(lines, names, units, []string{}, []string{})
+
+	buffered := bufio.NewWriter(w)
+	for _, line := range lines {
+		if err := writeMotecLine(buffered, line); err != nil {
+			return err
+		}
+	}
+
+	// Start every sensor at its first value
+	current := make([]float64, len(table.Columns))
+	for i, column := range table.Columns {
+		for _, value := range column {
+			if value != nil {
+				current[i] = *value
+				break
+			}
+		}
+	}
+
+	// Attempt to write the resampled rows
+	next := 0
+	line := make([]string, len(table.Columns)+1)
+	for row := 0; row < rows; row++ {
+		offset := float64(row) / float64(rate)
+		for next < len(table.Timestamps) && table.offset(next) <= offset {
+			for i, column := range table.Columns {
+				if column[next] != nil {
+					current[i] = *column[next]
+				}
+			}
+			next++
+		}
+		line[0] = strconv.FormatFloat(offset, 'f', 3, 64)
+		for i, value := range current {
+			line[i+1] = strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		if err := writeMotecLine(buffered, line); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+// Writes a line with every field quoted like the files i2 exports
+func writeMotecLine(w *bufio.Writer, fields []string) error {
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = "\"" + strings.ReplaceAll(field, "\"", "\"\"") + "\""
+	}
+	_, err := w.WriteString(strings.Join(quoted, ",") + "\r\n")
+	return err
+}
@@ -0,0 +1,86 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Rows written per parquet row group
+const parquetRowGroupSize = 65536
+
+// Rows handed to the parquet writer at once
+const parquetBatchSize = 1024
+
+// Name of the parquet column holding the timestamps
+const parquetTimestampColumn = "timestamp"
+
+// Writes the table as a parquet file with an int64 timestamp column in
+// milliseconds and an optional double column per sensor. The sensors and
+// session are kept as json in the file's key value metadata.
+func WriteParquet(w io.Writer, table *Table) error {
+	sensors, err := json.Marshal(table.Sensors)
+	if err != nil {
+		return err
+	}
+	session, err := json.Marshal(table.Session)
+	if err != nil {
+		return err
+	}
+
+	// Guard against a sensor whose column would replace the timestamps
+	group := parquet.Group{parquetTimestampColumn: parquet.Leaf(parquet.Int64Type)}
+	for _, sensor := range table.Sensors {
+		if sensor.Name == parquetTimestampColumn {
+			return errors.New("the sensor " + sensor.Name + " has the name of the timestamp column")
+		}
+		group[sensor.Name] = parquet.Optional(parquet.Leaf(parquet.DoubleType))
+	}
+	schema := parquet.NewSchema("schema", group)
+
+	// The schema orders its columns by name, find where each one is written
+	indexes := make(map[string]int)
+	for i, path := range schema.Columns() {
+		indexes[path[0]] = i
+	}
+	timestampIndex := indexes[parquetTimestampColumn]
+	columnIndexes := make([]int, len(table.Sensors))
+	for i, sensor := range table.Sensors {
+		columnIndexes[i] = indexes[sensor.Name]
+	}
+
+	writer := parquet.NewWriter(w, schema,
+		parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+		parquet.KeyValueMetadata("sensors", string(sensors)),
+		parquet.KeyValueMetadata("session", string(session)),
+		parquet.CreatedBy("SRVRelayMS", "1", ""),
+	)
+
+	// Sensor values are optional, missing ones only have a definition level of zero
+	rows := make([]parquet.Row, 0, parquetBatchSize)
+	for start := 0; start < len(table.Timestamps); start += parquetBatchSize {
+		end := start + parquetBatchSize
+		if end > len(table.Timestamps) {
+			end = len(table.Timestamps)
+		}
+		rows = rows[:0]
+		for row := start; row < end; row++ {
+			values := make(parquet.Row, len(table.Sensors)+1)
+			values[timestampIndex] = parquet.Int64Value(table.Timestamps[row]).Level(0, 0, timestampIndex)
+			for i, column := range table.Columns {
+				if column[row] == nil {
+					values[columnIndexes[i]] = parquet.NullValue().Level(0, 0, columnIndexes[i])
+				} else {
+					values[columnIndexes[i]] = parquet.DoubleValue(*column[row]).Level(0, 1, columnIndexes[i])
+				}
+			}
+			rows = append(rows, values)
+		}
+		if _, err := writer.WriteRows(rows); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
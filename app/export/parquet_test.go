@@ -0,0 +1,140 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Opens a parquet file and reads a column of values per column name, nil
+// where a value is missing
+func readParquet(t *testing.T, data []byte) (*parquet.File, map[string][]*float64) {
+	t.Helper()
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := file.Schema().Columns()
+	columns := make(map[string][]*float64)
+	reader := parquet.NewReader(file)
+	defer reader.Close()
+	rows := make([]parquet.Row, 16)
+	for {
+		n, err := reader.ReadRows(rows)
+		for _, row := range rows[:n] {
+			for _, value := range row {
+				name := names[value.Column()][0]
+				switch {
+				case value.IsNull():
+					columns[name] = append(columns[name], nil)
+				case value.Kind() == parquet.Int64:
+					columns[name] = append(columns[name], float(float64(value.Int64())))
+				default:
+					columns[name] = append(columns[name], float(value.Double()))
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return file, columns
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteParquetRoundTrip(t *testing.T) {
+	table := testTable()
+	buf := &bytes.Buffer{}
+	if err := WriteParquet(buf, table); err != nil {
+		t.Fatal(err)
+	}
+	file, columns := readParquet(t, buf.Bytes())
+
+	if file.NumRows() != int64(len(table.Timestamps)) {
+		t.Errorf("the file has %d rows, expected %d", file.NumRows(), len(table.Timestamps))
+	}
+	timestamp, ok := file.Schema().Lookup("timestamp")
+	if !ok || timestamp.Node.Optional() || timestamp.Node.Type().Kind() != parquet.Int64 {
+		t.Errorf("expected a required int64 timestamp column")
+	}
+	for i, expected := range table.Timestamps {
+		if *columns["timestamp"][i] != float64(expected) {
+			t.Errorf("timestamp %d is %v, expected %d", i, *columns["timestamp"][i], expected)
+		}
+	}
+	for i, sensor := range table.Sensors {
+		column, ok := file.Schema().Lookup(sensor.Name)
+		if !ok || !column.Node.Optional() || column.Node.Type().Kind() != parquet.Double {
+			t.Errorf("expected an optional double %s column", sensor.Name)
+		}
+		for row, want := range table.Columns[i] {
+			value := columns[sensor.Name][row]
+			if (value == nil) != (want == nil) || value != nil && *value != *want {
+				t.Errorf("%s row %d is %v, expected %v", sensor.Name, row, value, want)
+			}
+		}
+	}
+
+	// The sensors and their calibration are kept in the key value metadata
+	metadata, _ := file.Lookup("sensors")
+	sensors := []map[string]interface{}{}
+	if err := json.Unmarshal([]byte(metadata), &sensors); err != nil {
+		t.Fatal(err)
+	}
+	if len(sensors) != 2 || sensors[0]["lowerCalibration"] != 0.5 || sensors[0]["upperCalibration"] != 4.5 ||
+		sensors[0]["conversionMultiplier"] != 2.0 || sensors[1]["conversionMultiplier"] != 0.25 {
+		t.Errorf("unexpected sensors metadata %s", metadata)
+	}
+	if session, _ := file.Lookup("session"); session == "" {
+		t.Errorf("missing session metadata")
+	}
+}
+
+func TestWriteParquetRowGroups(t *testing.T) {
+	table := testTable()
+	rows := parquetRowGroupSize + 10
+	table.Timestamps = make([]int64, rows)
+	table.Columns = [][]*float64{make([]*float64, rows), make([]*float64, rows)}
+	for i := range table.Timestamps {
+		table.Timestamps[i] = int64(i)
+		table.Columns[0][i] = float(float64(i))
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteParquet(buf, table); err != nil {
+		t.Fatal(err)
+	}
+	file, columns := readParquet(t, buf.Bytes())
+	if len(file.RowGroups()) != 2 || file.NumRows() != int64(rows) {
+		t.Fatalf("expected %d rows in 2 row groups, got %d in %d", rows, file.NumRows(), len(file.RowGroups()))
+	}
+	if last := columns["rpm"][rows-1]; last == nil || *last != float64(rows-1) || columns["speed"][rows-1] != nil {
+		t.Errorf("unexpected last row %v %v", last, columns["speed"][rows-1])
+	}
+}
+
+func TestWriteParquetEmptyTable(t *testing.T) {
+	table := testTable()
+	table.Timestamps = nil
+	table.Columns = [][]*float64{nil, nil}
+	buf := &bytes.Buffer{}
+	if err := WriteParquet(buf, table); err != nil {
+		t.Fatal(err)
+	}
+	file, _ := readParquet(t, buf.Bytes())
+	if file.NumRows() != 0 {
+		t.Errorf("the file has %d rows, expected none", file.NumRows())
+	}
+}
+
+func TestWriteParquetRejectsTimestampSensor(t *testing.T) {
+	table := testTable()
+	table.Sensors[1].Name = "timestamp"
+	if err := WriteParquet(&bytes.Buffer{}, table); err == nil {
+		t.Fatal("expected the sensor named timestamp to be rejected")
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"database-ms/app/middleware"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	utils "database-ms/app/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type AlarmHandler struct {
+	alarmService   services.AlarmServiceInterface
+	sessionService services.SessionServiceInterface
+	thingService   services.ThingServiceInterface
+}
+
+func NewAlarmAPI(
+	alarmService services.AlarmServiceInterface,
+	sessionService services.SessionServiceInterface,
+	thingService services.ThingServiceInterface,
+) *AlarmHandler {
+	return &AlarmHandler{
+		alarmService:   alarmService,
+		sessionService: sessionService,
+		thingService:   thingService,
+	}
+}
+
+func (handler *AlarmHandler) GetSessionAlarms(ctx *gin.Context) {
+	// Attempt to read from the params
+	sessionId, err := uuid.Parse(ctx.Param("sessionId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+	query := &services.AlarmQuery{Level: ctx.Query("level")}
+	if query.Level != "" && query.Level != model.AlarmLevelWarning && query.Level != model.AlarmLevelDanger {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, "level must be warning or danger"))
+		return
+	}
+	if param := ctx.Query("sensorId"); param != "" {
+		sensorId, err := uuid.Parse(param)
+		if err != nil {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+			return
+		}
+		query.SensorId = &sensorId
+	}
+
+	// Attempt to read the session
+	session, perr := handler.sessionService.FindById(ctx.Request.Context(), sessionId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SessionNotFound))
+		return
+	}
+
+	// Attempt to read the thing
+	thing, perr := handler.thingService.FindById(ctx.Request.Context(), session.ThingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return
+	}
+
+	// Guard against cross-tenant reads
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to read the alarms
+	alarms, perr := handler.alarmService.FindBySessionId(ctx.Request.Context(), sessionId, query)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.AlarmsNotFound))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(alarms, "Successfully retrieved alarms.")
+	utils.Response(ctx, http.StatusOK, result)
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"database-ms/app/middleware"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	utils "database-ms/app/utils"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CompareHandler struct {
+	compareService       services.CompareServiceInterface
+	collectionService    services.CollectionServiceInterface
+	sessionService       services.SessionServiceInterface
+	sessionSensorService services.SessionSensorServiceInterface
+	thingService         services.ThingServiceInterface
+}
+
+func NewCompareAPI(
+	compareService services.CompareServiceInterface,
+	collectionService services.CollectionServiceInterface,
+	sessionService services.SessionServiceInterface,
+	sessionSensorService services.SessionSensorServiceInterface,
+	thingService services.ThingServiceInterface,
+) *CompareHandler {
+	return &CompareHandler{
+		compareService:       compareService,
+		collectionService:    collectionService,
+		sessionService:       sessionService,
+		sessionSensorService: sessionSensorService,
+		thingService:         thingService,
+	}
+}
+
+func (handler *CompareHandler) CompareCollection(ctx *gin.Context) {
+	// Attempt to read from the params
+	collectionId, err := uuid.Parse(ctx.Param("collectionId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+	query, err := parseCompareQuery(ctx)
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+
+	// Attempt to find the collection
+	collection, perr := handler.collectionService.FindById(ctx.Request.Context(), collectionId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.CollectionNotFound))
+		return
+	}
+
+	// Attempt to find the collection's thing
+	thing, perr := handler.thingService.FindById(ctx.Request.Context(), collection.ThingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return
+	}
+
+	// Guard against cross-tenant reads
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to read the sessions in the order they were recorded
+	var sessions []*model.Session
+	for _, sessionId := range collection.SessionIds {
+		session, perr := handler.sessionService.FindById(ctx.Request.Context(), sessionId)
+		if perr != nil {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SessionNotFound))
+			return
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartTime < sessions[j].StartTime
+	})
+
+	// Guard against sensors that none of the sessions were recorded with,
+	// sensors deleted or renamed since are still compared
+	recorded := make(map[uuid.UUID]bool)
+	for _, session := range sessions {
+		sensors, perr := handler.sessionSensorService.FindSensorsBySession(ctx.Request.Context(), session)
+		if perr != nil {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SensorsNotFound))
+			return
+		}
+		for _, sensor := range sensors {
+			recorded[sensor.Id] = true
+		}
+	}
+	requested := query.SensorIds
+	if query.DistanceSensorId != nil {
+		requested = append(append([]uuid.UUID{}, requested...), *query.DistanceSensorId)
+	}
+	for _, sensorId := range requested {
+		if !recorded[sensorId] {
+			utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.SensorNotFound))
+			return
+		}
+	}
+
+	// Guard against a baseline from outside the collection
+	if query.BaselineSessionId != nil && !containsSession(sessions, *query.BaselineSessionId) {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, "The baseline session is not in the collection."))
+		return
+	}
+
+	// Attempt to compare the sessions
+	comparison, perr := handler.compareService.Compare(ctx.Request.Context(), sessions, query)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.InternalError))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(comparison, "Successfully compared sessions.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+// Reads the sensorIds, align, distanceSensorId and baselineSessionId query
+// params along with the downsampling options
+func parseCompareQuery(ctx *gin.Context) (*services.CompareQuery, error) {
+	datumQuery, err := parseDatumQuery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := &services.CompareQuery{
+		Align: ctx.DefaultQuery("align", services.AlignTime),
+		Datum: &services.DatumQuery{MaxPoints: datumQuery.MaxPoints, Aggregation: datumQuery.Aggregation, Fill: datumQuery.Fill},
+	}
+
+	// Attempt to parse the comma separated sensor ids, removing duplicates
+	seen := make(map[uuid.UUID]bool)
+	for _, param := range ctx.QueryArray("sensorIds") {
+		for _, value := range strings.Split(param, ",") {
+			sensorId, err := uuid.Parse(strings.TrimSpace(value))
+			if err != nil {
+				return nil, err
+			}
+			if !seen[sensorId] {
+				seen[sensorId] = true
+				query.SensorIds = append(query.SensorIds, sensorId)
+			}
+		}
+	}
+	if len(query.SensorIds) == 0 {
+		return nil, errors.New("no sensors were requested")
+	}
+
+	switch query.Align {
+	case services.AlignTime, services.AlignLap:
+	case services.AlignDistance:
+		sensorId, err := uuid.Parse(ctx.Query("distanceSensorId"))
+		if err != nil {
+			return nil, errors.New("distanceSensorId is required to align on distance")
+		}
+		query.DistanceSensorId = &sensorId
+	default:
+		return nil, errors.New("align must be time, distance or lap")
+	}
+
+	if param := ctx.Query("baselineSessionId"); param != "" {
+		sessionId, err := uuid.Parse(param)
+		if err != nil {
+			return nil, err
+		}
+		query.BaselineSessionId = &sessionId
+	}
+	return query, nil
+}
+
+func containsSession(sessions []*model.Session, sessionId uuid.UUID) bool {
+	for _, session := range sessions {
+		if session.Id == sessionId {
+			return true
+		}
+	}
+	return false
+}
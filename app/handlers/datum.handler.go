@@ -2,32 +2,42 @@ package handlers
 
 import (
 	"database-ms/app/middleware"
+	"database-ms/app/model"
 	"database-ms/app/services"
 	utils "database-ms/app/utils"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 type DatumHandler struct {
-	datumService   services.DatumServiceInterface
-	thingService   services.ThingServiceInterface
-	sensorService  services.SensorServiceInterface
-	sessionService services.SessionServiceInterface
+	datumService         services.DatumServiceInterface
+	thingService         services.ThingServiceInterface
+	sessionSensorService services.SessionSensorServiceInterface
+	sessionService       services.SessionServiceInterface
+	chartPresetService   services.ChartPresetServiceInterface
+	rawDataPresetService services.RawDataPresetServiceInterface
 }
 
 func NewDatumAPI(
 	datumService services.DatumServiceInterface,
 	thingService services.ThingServiceInterface,
-	sensorService services.SensorServiceInterface,
+	sessionSensorService services.SessionSensorServiceInterface,
 	sessionService services.SessionServiceInterface,
+	chartPresetService services.ChartPresetServiceInterface,
+	rawDataPresetService services.RawDataPresetServiceInterface,
 ) *DatumHandler {
 	return &DatumHandler{
-		datumService:   datumService,
-		thingService:   thingService,
-		sensorService:  sensorService,
-		sessionService: sessionService,
+		datumService:         datumService,
+		thingService:         thingService,
+		sessionSensorService: sessionSensorService,
+		sessionService:       sessionService,
+		chartPresetService:   chartPresetService,
+		rawDataPresetService: rawDataPresetService,
 	}
 }
 
@@ -44,59 +54,246 @@ func (handler *DatumHandler) GetSensorData(ctx *gin.Context) {
 		return
 	}
 
-	// Attempt to find the session
-	session, perr := handler.sessionService.FindById(ctx, sessionId)
-	if perr != nil {
-		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.SessionNotFound))
+	// Attempt to read the window and downsampling options
+	query, err := parseDatumQuery(ctx)
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
 		return
 	}
 
-	// If the session file is uploaded, its data is not available
-	if *session.Generated != true {
-		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.BadRequest))
+	// Attempt to find the session and guard against cross-tenant reads
+	session := handler.findReadableSession(ctx, sessionId)
+	if session == nil {
 		return
 	}
 
-	// Attempt to find the sensor
-	sensor, perr := handler.sensorService.FindById(ctx, sensorId)
-	if perr != nil {
-		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.SensorNotFound))
+	// Guard against sensors that do not belong to the session's thing
+	if !handler.areSessionSensors(ctx, session, []uuid.UUID{sensorId}) {
 		return
 	}
 
-	// Attempt to find the session and sensor thing
-	sessionThing, perr := handler.thingService.FindById(ctx, session.ThingId)
+	// Attempt to get the data
+	data, perr := handler.datumService.FindBySessionIdAndSensorId(ctx, sessionId, sensorId, query)
 	if perr != nil {
-		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.ThingNotFound))
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.InternalError))
 		return
 	}
-	sensorThing, perr := handler.thingService.FindById(ctx, sensor.ThingId)
-	if perr != nil {
-		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.ThingNotFound))
+
+	// Send the response
+	result := utils.SuccessPayload(data, "Successfully retrieved sensor data.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+func (handler *DatumHandler) GetSessionData(ctx *gin.Context) {
+	// Attempt to read from the params
+	sessionId, err := uuid.Parse(ctx.Param("sessionId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
 		return
 	}
 
-	// Guard against non-matching things between the session and sensor
-	if sessionThing.Id != sensorThing.Id {
-		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+	// Attempt to read the window and downsampling options
+	query, err := parseDatumQuery(ctx)
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
 		return
 	}
 
-	// Guard against cross-tenant reads
-	organization, _ := middleware.GetOrganizationClaim(ctx)
-	if sessionThing.OrganizationId != organization.Id {
-		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+	// Attempt to find the session and guard against cross-tenant reads
+	session := handler.findReadableSession(ctx, sessionId)
+	if session == nil {
 		return
 	}
 
-	// Attempt to get the data
-	data, perr := handler.datumService.FindBySessionIdAndSensorId(ctx, sessionId, sensorId)
+	// Attempt to resolve the requested sensors
+	sensorIds := handler.findRequestedSensorIds(ctx, session)
+	if sensorIds == nil {
+		return
+	}
+
+	// Guard against sensors that do not belong to the session's thing
+	if !handler.areSessionSensors(ctx, session, sensorIds) {
+		return
+	}
+
+	// Attempt to get the aligned data
+	data, perr := handler.datumService.FindAlignedBySessionId(ctx, sessionId, sensorIds, query)
 	if perr != nil {
 		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.InternalError))
 		return
 	}
 
 	// Send the response
-	result := utils.SuccessPayload(data, "Successfully retrieved sensor data.")
+	result := utils.SuccessPayload(data, "Successfully retrieved session data.")
 	utils.Response(ctx, http.StatusOK, result)
 }
+
+// Finds the session if the requester's organization owns it, otherwise responds with an error
+func (handler *DatumHandler) findReadableSession(ctx *gin.Context, sessionId uuid.UUID) *model.Session {
+	// Attempt to find the session
+	session, perr := handler.sessionService.FindById(ctx, sessionId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.SessionNotFound))
+		return nil
+	}
+
+	// Attempt to find the session thing
+	sessionThing, perr := handler.thingService.FindById(ctx, session.ThingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.ThingNotFound))
+		return nil
+	}
+
+	// Guard against cross-tenant reads
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	if sessionThing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return nil
+	}
+	return session
+}
+
+// Checks that every sensor was recorded in the session, otherwise responds with an error
+func (handler *DatumHandler) areSessionSensors(ctx *gin.Context, session *model.Session, sensorIds []uuid.UUID) bool {
+	// Attempt to read the sensors the session was recorded with
+	sensors, perr := handler.sessionSensorService.FindSensorsBySession(ctx.Request.Context(), session)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SensorsNotFound))
+		return false
+	}
+
+	// Guard against sensors from outside the session
+	recorded := make(map[uuid.UUID]bool)
+	for _, sensor := range sensors {
+		recorded[sensor.Id] = true
+	}
+	for _, sensorId := range sensorIds {
+		if !recorded[sensorId] {
+			utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.SensorNotFound))
+			return false
+		}
+	}
+	return true
+}
+
+// Reads the sensors from the sensorIds, chartPresetId or rawDataPresetId query params
+func (handler *DatumHandler) findRequestedSensorIds(ctx *gin.Context, session *model.Session) []uuid.UUID {
+	var requested []uuid.UUID
+	if chartPresetParam := ctx.Query("chartPresetId"); chartPresetParam != "" {
+		// Attempt to read the sensors of every chart in the preset
+		chartPresetId, err := uuid.Parse(chartPresetParam)
+		if err != nil {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+			return nil
+		}
+		chartPreset, perr := handler.chartPresetService.FindById(ctx, chartPresetId)
+		if perr != nil {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ChartPresetNotFound))
+			return nil
+		}
+		if chartPreset.ThingId != session.ThingId {
+			utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+			return nil
+		}
+		for _, chart := range chartPreset.Charts {
+			requested = append(requested, chart.SensorIds...)
+		}
+	} else if rawDataPresetParam := ctx.Query("rawDataPresetId"); rawDataPresetParam != "" {
+		// Attempt to read the sensors of the raw data preset
+		rawDataPresetId, err := uuid.Parse(rawDataPresetParam)
+		if err != nil {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+			return nil
+		}
+		rawDataPreset, perr := handler.rawDataPresetService.FindById(ctx, rawDataPresetId)
+		if perr != nil {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.RawDataPresetNotFound))
+			return nil
+		}
+		if rawDataPreset.ThingId != session.ThingId {
+			utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+			return nil
+		}
+		requested = rawDataPreset.SensorIds
+	} else {
+		// Attempt to parse the comma separated sensor ids
+		for _, param := range ctx.QueryArray("sensorIds") {
+			for _, value := range strings.Split(param, ",") {
+				sensorId, err := uuid.Parse(strings.TrimSpace(value))
+				if err != nil {
+					utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+					return nil
+				}
+				requested = append(requested, sensorId)
+			}
+		}
+	}
+
+	// Remove duplicates while keeping the requested order
+	sensorIds := []uuid.UUID{}
+	seen := make(map[uuid.UUID]bool)
+	for _, sensorId := range requested {
+		if !seen[sensorId] {
+			seen[sensorId] = true
+			sensorIds = append(sensorIds, sensorId)
+		}
+	}
+	if len(sensorIds) == 0 {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, "No sensors were requested."))
+		return nil
+	}
+	return sensorIds
+}
+
+// Reads the optional from, to, maxPoints, aggregation and fill query params
+func parseDatumQuery(ctx *gin.Context) (*services.DatumQuery, error) {
+	query := &services.DatumQuery{Aggregation: services.AggregationLttb}
+	if from := ctx.Query("from"); from != "" {
+		value, err := strconv.ParseInt(from, 10, 64)
+		if err != nil {
+			return nil, errors.New("from must be a timestamp in milliseconds")
+		}
+		query.From = &value
+	}
+	if to := ctx.Query("to"); to != "" {
+		value, err := strconv.ParseInt(to, 10, 64)
+		if err != nil {
+			return nil, errors.New("to must be a timestamp in milliseconds")
+		}
+		query.To = &value
+	}
+	if query.From != nil && query.To != nil && *query.From > *query.To {
+		return nil, errors.New("from must not be after to")
+	}
+	if maxPoints := ctx.Query("maxPoints"); maxPoints != "" {
+		value, err := strconv.Atoi(maxPoints)
+		if err != nil || value < 3 {
+			return nil, errors.New("maxPoints must be an integer of at least 3")
+		}
+		query.MaxPoints = value
+	}
+	if aggregation := ctx.Query("aggregation"); aggregation != "" {
+		switch aggregation {
+		case services.AggregationAvg, services.AggregationMin, services.AggregationMax, services.AggregationLttb:
+			query.Aggregation = aggregation
+		default:
+			return nil, errors.New("aggregation must be one of avg, min, max or lttb")
+		}
+	}
+	fill, err := parseFill(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query.Fill = fill
+	return query, nil
+}
+
+// Reads the optional fill query param, nothing is filled by default
+func parseFill(ctx *gin.Context) (string, error) {
+	switch fill := ctx.DefaultQuery("fill", services.FillNone); fill {
+	case services.FillNone, services.FillHold, services.FillLinear:
+		return fill, nil
+	default:
+		return "", errors.New("fill must be one of hold, linear or none")
+	}
+}
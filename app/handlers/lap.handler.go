@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"database-ms/app/middleware"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	utils "database-ms/app/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+)
+
+type LapHandler struct {
+	lapService           services.LapServiceInterface
+	datumService         services.DatumServiceInterface
+	sessionService       services.SessionServiceInterface
+	sessionSensorService services.SessionSensorServiceInterface
+	collectionService    services.CollectionServiceInterface
+	thingService         services.ThingServiceInterface
+}
+
+func NewLapAPI(
+	lapService services.LapServiceInterface,
+	datumService services.DatumServiceInterface,
+	sessionService services.SessionServiceInterface,
+	sessionSensorService services.SessionSensorServiceInterface,
+	collectionService services.CollectionServiceInterface,
+	thingService services.ThingServiceInterface,
+) *LapHandler {
+	return &LapHandler{
+		lapService:           lapService,
+		datumService:         datumService,
+		sessionService:       sessionService,
+		sessionSensorService: sessionSensorService,
+		collectionService:    collectionService,
+		thingService:         thingService,
+	}
+}
+
+func (handler *LapHandler) DetectLaps(ctx *gin.Context) {
+	// Guard against unauthorized users
+	if !middleware.IsAuthorizationAtLeast(ctx, "Lead") {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to read from the params and body
+	sessionId, err := uuid.Parse(ctx.Param("sessionId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+	var detection services.LapDetection
+	if err := ctx.ShouldBindJSON(&detection); err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.InvalidBindingModel))
+		return
+	}
+
+	// Attempt to find the session and guard against cross-tenant writes
+	session := handler.findReadableSession(ctx, sessionId)
+	if session == nil {
+		return
+	}
+
+	// Guard against sensors that do not belong to the session's thing
+	var sensorIds []uuid.UUID
+	for _, sensorId := range []*uuid.UUID{detection.BeaconSensorId, detection.LatitudeSensorId, detection.LongitudeSensorId} {
+		if sensorId != nil {
+			sensorIds = append(sensorIds, *sensorId)
+		}
+	}
+	if !handler.areSessionSensors(ctx, session, sensorIds) {
+		return
+	}
+
+	// Attempt to detect the laps
+	laps, err := handler.lapService.Detect(ctx.Request.Context(), sessionId, &detection)
+	if err != nil {
+		if _, ok := err.(*pgconn.PgError); ok {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.InternalError))
+			return
+		}
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.InvalidLapDetection, err.Error()))
+		return
+	}
+
+	// Attempt to replace the session's laps
+	if perr := handler.lapService.ReplaceBySessionId(ctx.Request.Context(), sessionId, laps); perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.InternalError))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(laps, "Successfully detected laps.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+func (handler *LapHandler) GetSessionLaps(ctx *gin.Context) {
+	// Attempt to read from the params
+	sessionId, err := uuid.Parse(ctx.Param("sessionId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+
+	// Attempt to find the session and guard against cross-tenant reads
+	if handler.findReadableSession(ctx, sessionId) == nil {
+		return
+	}
+
+	// Attempt to read the laps
+	laps, perr := handler.lapService.FindBySessionId(ctx.Request.Context(), sessionId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.LapsNotFound))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(laps, "Successfully retrieved laps.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+func (handler *LapHandler) GetLapData(ctx *gin.Context) {
+	// Attempt to read from the params
+	lapId, err := uuid.Parse(ctx.Param("lapId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+
+	// Attempt to read the window and downsampling options
+	query, err := parseDatumQuery(ctx)
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+
+	// Attempt to parse the comma separated sensor ids, removing duplicates
+	sensorIds := []uuid.UUID{}
+	seen := make(map[uuid.UUID]bool)
+	for _, param := range ctx.QueryArray("sensorIds") {
+		for _, value := range strings.Split(param, ",") {
+			sensorId, err := uuid.Parse(strings.TrimSpace(value))
+			if err != nil {
+				utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+				return
+			}
+			if !seen[sensorId] {
+				seen[sensorId] = true
+				sensorIds = append(sensorIds, sensorId)
+			}
+		}
+	}
+	if len(sensorIds) == 0 {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, "No sensors were requested."))
+		return
+	}
+
+	// Attempt to find the lap
+	lap, perr := handler.lapService.FindById(ctx.Request.Context(), lapId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.LapNotFound))
+		return
+	}
+
+	// Attempt to find the session and guard against cross-tenant reads
+	session := handler.findReadableSession(ctx, lap.SessionId)
+	if session == nil {
+		return
+	}
+
+	// Guard against sensors that do not belong to the session's thing
+	if !handler.areSessionSensors(ctx, session, sensorIds) {
+		return
+	}
+
+	// Narrow the requested window to the lap
+	if query.From == nil || *query.From < lap.StartTime {
+		query.From = &lap.StartTime
+	}
+	if query.To == nil || *query.To > lap.EndTime {
+		query.To = &lap.EndTime
+	}
+
+	// Attempt to get the aligned data
+	data, perr := handler.datumService.FindAlignedBySessionId(ctx.Request.Context(), lap.SessionId, sensorIds, query)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.InternalError))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(data, "Successfully retrieved lap data.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+func (handler *LapHandler) GetFastestLap(ctx *gin.Context) {
+	// Attempt to read from the params
+	collectionId, err := uuid.Parse(ctx.Param("collectionId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+
+	// Attempt to find the collection
+	collection, perr := handler.collectionService.FindById(ctx.Request.Context(), collectionId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.CollectionNotFound))
+		return
+	}
+
+	// Attempt to find the collection's thing
+	thing, perr := handler.thingService.FindById(ctx.Request.Context(), collection.ThingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return
+	}
+
+	// Guard against cross-tenant reads
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to find the fastest lap of the collection's sessions
+	lap, perr := handler.lapService.FindFastestBySessionIds(ctx.Request.Context(), collection.SessionIds)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.InternalError))
+		return
+	}
+	if lap == nil {
+		utils.Response(ctx, http.StatusNotFound, utils.NewHTTPError(utils.LapNotFound))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(lap, "Successfully retrieved the fastest lap.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+// Finds the session if the requester's organization owns it, otherwise responds with an error
+func (handler *LapHandler) findReadableSession(ctx *gin.Context, sessionId uuid.UUID) *model.Session {
+	// Attempt to find the session
+	session, perr := handler.sessionService.FindById(ctx.Request.Context(), sessionId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SessionNotFound))
+		return nil
+	}
+
+	// Attempt to find the session thing
+	thing, perr := handler.thingService.FindById(ctx.Request.Context(), session.ThingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return nil
+	}
+
+	// Guard against cross-tenant access
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return nil
+	}
+	return session
+}
+
+// Checks that every sensor was recorded in the session, otherwise responds with an error
+func (handler *LapHandler) areSessionSensors(ctx *gin.Context, session *model.Session, sensorIds []uuid.UUID) bool {
+	sensors, perr := handler.sessionSensorService.FindSensorsBySession(ctx.Request.Context(), session)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SensorsNotFound))
+		return false
+	}
+	recorded := make(map[uuid.UUID]bool)
+	for _, sensor := range sensors {
+		recorded[sensor.Id] = true
+	}
+	for _, sensorId := range sensorIds {
+		if !recorded[sensorId] {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SensorNotFound))
+			return false
+		}
+	}
+	return true
+}
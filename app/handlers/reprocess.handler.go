@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"database-ms/app/middleware"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"database-ms/app/subscriber"
+	utils "database-ms/app/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+)
+
+type ReprocessHandler struct {
+	reprocessor    *subscriber.Reprocessor
+	sessionService services.SessionServiceInterface
+	thingService   services.ThingServiceInterface
+}
+
+func NewReprocessAPI(
+	reprocessor *subscriber.Reprocessor,
+	sessionService services.SessionServiceInterface,
+	thingService services.ThingServiceInterface,
+) *ReprocessHandler {
+	return &ReprocessHandler{
+		reprocessor:    reprocessor,
+		sessionService: sessionService,
+		thingService:   thingService,
+	}
+}
+
+func (handler *ReprocessHandler) ReprocessSession(ctx *gin.Context) {
+	// Guard against non-lead+ requests
+	if !middleware.IsAuthorizationAtLeast(ctx, "Lead") {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to find the session and guard against cross-tenant writes
+	session := handler.findSession(ctx)
+	if session == nil {
+		return
+	}
+
+	// Attempt to start reprocessing the session
+	job, err := handler.reprocessor.Start(ctx.Request.Context(), session)
+	if err != nil {
+		if _, ok := err.(*pgconn.PgError); ok {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.InternalError))
+			return
+		}
+		utils.Response(ctx, http.StatusConflict, utils.NewHTTPCustomError(utils.CannotReprocess, err.Error()))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(job, "Successfully started reprocessing the session.")
+	utils.Response(ctx, http.StatusAccepted, result)
+}
+
+func (handler *ReprocessHandler) GetReprocessJob(ctx *gin.Context) {
+	// Attempt to find the session and guard against cross-tenant reads
+	session := handler.findSession(ctx)
+	if session == nil {
+		return
+	}
+
+	// Attempt to find the session's last job
+	job := handler.reprocessor.Find(session.Id)
+	if job == nil {
+		utils.Response(ctx, http.StatusNotFound, utils.NewHTTPError(utils.ReprocessJobNotFound))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(job, "Successfully retrieved the reprocessing job.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+// Finds the session of the sessionId param if the requester's organization
+// owns it, otherwise responds with an error
+func (handler *ReprocessHandler) findSession(ctx *gin.Context) *model.Session {
+	// Attempt to read from the params
+	sessionId, err := uuid.Parse(ctx.Param("sessionId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return nil
+	}
+
+	// Attempt to find the session
+	session, perr := handler.sessionService.FindById(ctx.Request.Context(), sessionId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SessionNotFound))
+		return nil
+	}
+
+	// Attempt to find the session's thing
+	thing, perr := handler.thingService.FindById(ctx.Request.Context(), session.ThingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return nil
+	}
+
+	// Guard against cross-tenant access
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return nil
+	}
+	return session
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"database-ms/app/middleware"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"database-ms/app/storage"
+	utils "database-ms/app/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RetentionHandler struct {
+	retentionService services.RetentionServiceInterface
+	storage          storage.Storage
+}
+
+func NewRetentionAPI(retentionService services.RetentionServiceInterface, store storage.Storage) *RetentionHandler {
+	return &RetentionHandler{retentionService: retentionService, storage: store}
+}
+
+func (handler *RetentionHandler) GetRetentionPolicy(ctx *gin.Context) {
+	// Guard against non-admin users
+	if !middleware.IsAuthorizationAtLeast(ctx, "Admin") {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to read the policy
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	policy, perr := handler.retentionService.FindByOrganizationId(ctx.Request.Context(), organization.Id)
+	if perr != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.RetentionPolicyNotFound))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(policy, "Successfully retrieved retention policy.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+func (handler *RetentionHandler) UpdateRetentionPolicy(ctx *gin.Context) {
+	// Guard against non-admin users
+	if !middleware.IsAuthorizationAtLeast(ctx, "Admin") {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to extract the body
+	var policy model.RetentionPolicy
+	if err := ctx.BindJSON(&policy); err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.BadRequest))
+		return
+	}
+	if !policy.IsValid() {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.InvalidRetentionPolicy))
+		return
+	}
+
+	// Attempt to save the policy, it always belongs to the requestor's organization
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	policy.OrganizationId = organization.Id
+	if perr := handler.retentionService.Save(ctx.Request.Context(), &policy); perr != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPCustomError(utils.InternalError, perr.Error()))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(policy, "Successfully updated retention policy.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+func (handler *RetentionHandler) GetStorageUsage(ctx *gin.Context) {
+	// Guard against non-admin users
+	if !middleware.IsAuthorizationAtLeast(ctx, "Admin") {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to read the usage of the organization's things
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	things, perr := handler.retentionService.FindUsageByOrganizationId(ctx.Request.Context(), organization.Id)
+	if perr != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.StorageUsageNotFound))
+		return
+	}
+
+	// Add the size of each session's file
+	for _, thing := range things {
+		for _, session := range thing.Sessions {
+			key := storage.Key(thing.ThingId, session.SessionId)
+			session.FileBytes, session.Archived = storage.FileSize(ctx.Request.Context(), handler.storage, key)
+			thing.FileBytes += session.FileBytes
+		}
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(things, "Successfully retrieved storage usage.")
+	utils.Response(ctx, http.StatusOK, result)
+}
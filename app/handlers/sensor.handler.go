@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"database-ms/app/dbc"
+	"database-ms/app/derived"
 	"database-ms/app/middleware"
 	"database-ms/app/model"
 	services "database-ms/app/services"
 	utils "database-ms/app/utils"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -13,12 +16,17 @@ import (
 )
 
 type SensorHandler struct {
-	sensorService services.SensorServiceInterface
-	thingService  services.ThingServiceInterface
+	sensorService      services.SensorServiceInterface
+	thingService       services.ThingServiceInterface
+	calibrationService services.CalibrationServiceInterface
 }
 
-func NewSensorAPI(sensorService services.SensorServiceInterface, thingService services.ThingServiceInterface) *SensorHandler {
-	return &SensorHandler{sensorService: sensorService, thingService: thingService}
+func NewSensorAPI(
+	sensorService services.SensorServiceInterface,
+	thingService services.ThingServiceInterface,
+	calibrationService services.CalibrationServiceInterface,
+) *SensorHandler {
+	return &SensorHandler{sensorService: sensorService, thingService: thingService, calibrationService: calibrationService}
 }
 
 func (handler *SensorHandler) CreateSensor(ctx *gin.Context) {
@@ -50,6 +58,11 @@ func (handler *SensorHandler) CreateSensor(ctx *gin.Context) {
 		return
 	}
 
+	// Guard against derived sensors that cannot be computed
+	if !handler.areExpressionsValid(ctx, newSensor.ThingId, &newSensor, nil) {
+		return
+	}
+
 	// Attempt to create the sensor
 	perr = handler.sensorService.Create(ctx.Request.Context(), &newSensor)
 	if perr != nil {
@@ -170,6 +183,11 @@ func (handler *SensorHandler) UpdateSensor(ctx *gin.Context) {
 		return
 	}
 
+	// Guard against derived sensors that cannot be computed
+	if !handler.areExpressionsValid(ctx, updatedSensor.ThingId, &updatedSensor, nil) {
+		return
+	}
+
 	// Attempt to update the sensor
 	perr = handler.sensorService.Update(ctx.Request.Context(), &updatedSensor)
 	if perr != nil {
@@ -221,6 +239,11 @@ func (handler *SensorHandler) DeleteSensor(ctx *gin.Context) {
 		return
 	}
 
+	// Guard against deleting the input of a derived sensor
+	if !handler.areExpressionsValid(ctx, sensor.ThingId, nil, &sensorId) {
+		return
+	}
+
 	// Attempt to delete the sensor
 	perr = handler.sensorService.Delete(ctx.Request.Context(), sensorId)
 	if perr != nil {
@@ -232,3 +255,235 @@ func (handler *SensorHandler) DeleteSensor(ctx *gin.Context) {
 	result := utils.SuccessPayload(nil, "Successfully deleted")
 	utils.Response(ctx, http.StatusOK, result)
 }
+
+func (handler *SensorHandler) GetCalibrations(ctx *gin.Context) {
+	// Attempt to read from the params
+	sensorId, err := uuid.Parse(ctx.Param("sensorId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+
+	// Attempt to find the sensor
+	sensor, perr := handler.sensorService.FindById(ctx, sensorId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SensorNotFound))
+		return
+	}
+
+	// Attempt to find the thing
+	thing, perr := handler.thingService.FindById(ctx, sensor.ThingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return
+	}
+
+	// Guard against cross-tenant reading
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to read the calibration history
+	calibrations, perr := handler.calibrationService.FindBySensorId(ctx.Request.Context(), sensorId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.CalibrationsNotFound))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(calibrations, "Successfully retrieved calibrations")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+func (handler *SensorHandler) ImportDbc(ctx *gin.Context) {
+	// Guard against non-admin+ requests
+	if !middleware.IsAuthorizationAtLeast(ctx, "Admin") {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to read from the params
+	thingId, err := uuid.Parse(ctx.Param("thingId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+	apply, err := strconv.ParseBool(ctx.DefaultQuery("apply", "false"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+
+	// Attempt to find the thing
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	thing, perr := handler.thingService.FindById(ctx, thingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return
+	}
+
+	// Guard against cross-tenant writing
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to parse the file
+	file, err := ctx.FormFile("file")
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.NoFileRcvd))
+		return
+	}
+	upload, err := file.Open()
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.NoFileRcvd))
+		return
+	}
+	defer upload.Close()
+	database, err := dbc.Parse(upload)
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.InvalidDbc, err.Error()))
+		return
+	}
+
+	// Compare the file with the current sensors
+	sensors, perr := handler.sensorService.FindByThingId(ctx.Request.Context(), thingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SensorsNotFound))
+		return
+	}
+	plan := dbc.PlanSensors(thingId, sensors, database)
+	if !apply {
+		result := utils.SuccessPayload(plan, withSkipped("Successfully previewed DBC import", plan))
+		utils.Response(ctx, http.StatusOK, result)
+		return
+	}
+
+	// Guard against running out of small ids
+	if !plan.Fits() {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.NoAvailableSmallIds))
+		return
+	}
+
+	// Attempt to apply the changes
+	updated := make([]*model.Sensor, len(plan.Update))
+	for i, update := range plan.Update {
+		updated[i] = update.Sensor
+	}
+	perr = handler.sensorService.Import(ctx.Request.Context(), thingId, plan.Create, updated)
+	if perr != nil {
+		if perr.Code == "23505" {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.SensorNotUnique, perr.Error()))
+		} else if perr.Code == services.CodeInvalidExpression {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.InvalidExpression, perr.Message))
+		} else {
+			utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.EntityCreationError))
+		}
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(plan, withSkipped("Successfully imported DBC", plan))
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+// Adds the signals a DBC import skips to its response message
+func withSkipped(message string, plan *dbc.SensorPlan) string {
+	if skipped := plan.Skipped(); skipped != "" {
+		return message + ", " + skipped + "."
+	}
+	return message + "."
+}
+
+func (handler *SensorHandler) ExportDbc(ctx *gin.Context) {
+	thing, sensors := handler.findReadableThingSensors(ctx)
+	if thing == nil {
+		return
+	}
+
+	// Send the response
+	ctx.Header("Content-Disposition", "attachment; filename=\""+thing.Name+".dbc\"")
+	ctx.Header("Content-Type", "application/octet-stream")
+	ctx.Status(http.StatusOK)
+	if err := dbc.Write(ctx.Writer, sensors); err != nil {
+		log.Println("Failed to export DBC for " + thing.Id.String() + ": " + err.Error())
+	}
+}
+
+func (handler *SensorHandler) GetSensorSchema(ctx *gin.Context) {
+	thing, sensors := handler.findReadableThingSensors(ctx)
+	if thing == nil {
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(dbc.Schema(thing, sensors), "Successfully retrieved sensor schema")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+// Reads the thing in the params and its sensors, responds and returns nil if
+// they cannot be read
+func (handler *SensorHandler) findReadableThingSensors(ctx *gin.Context) (*model.Thing, []*model.Sensor) {
+	// Attempt to read from the params
+	thingId, err := uuid.Parse(ctx.Param("thingId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return nil, nil
+	}
+
+	// Attempt to find the thing
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	thing, perr := handler.thingService.FindById(ctx, thingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return nil, nil
+	}
+
+	// Guard against cross-tenant reading
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return nil, nil
+	}
+
+	// Attempt to read the sensors
+	sensors, perr := handler.sensorService.FindByThingId(ctx.Request.Context(), thingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SensorsNotFound))
+		return nil, nil
+	}
+	return thing, sensors
+}
+
+// Checks that the thing's derived sensors can still be computed once the
+// sensor is saved or removed, otherwise responds with an error
+func (handler *SensorHandler) areExpressionsValid(ctx *gin.Context, thingId uuid.UUID, saved *model.Sensor, removedId *uuid.UUID) bool {
+	if saved != nil && !saved.IsDerived() {
+		saved.Expression = ""
+	}
+
+	// Attempt to read the thing's sensors
+	existing, perr := handler.sensorService.FindByThingId(ctx.Request.Context(), thingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SensorsNotFound))
+		return false
+	}
+
+	// Replace the saved sensor and leave out the removed one
+	var sensors []*model.Sensor
+	for _, sensor := range existing {
+		if (saved != nil && sensor.Id == saved.Id) || (removedId != nil && sensor.Id == *removedId) {
+			continue
+		}
+		sensors = append(sensors, sensor)
+	}
+	if saved != nil {
+		sensors = append(sensors, saved)
+	}
+	if _, err := derived.Compile(sensors); err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.InvalidExpression, err.Error()))
+		return false
+	}
+	return true
+}
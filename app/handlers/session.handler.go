@@ -1,35 +1,60 @@
 package handlers
 
 import (
-	"bytes"
+	"compress/gzip"
+	"database-ms/app/export"
 	"database-ms/app/middleware"
 	"database-ms/app/model"
 	services "database-ms/app/services"
+	"database-ms/app/storage"
+	"database-ms/app/subscriber"
 	utils "database-ms/app/utils"
+	"encoding/json"
+	"errors"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 )
 
+// How often an idle live stream is kept alive
+const liveKeepAliveInterval = 15 * time.Second
+
 type SessionHandler struct {
-	session  services.SessionServiceInterface
-	thing    services.ThingServiceInterface
-	filepath string
+	session       services.SessionServiceInterface
+	thing         services.ThingServiceInterface
+	sessionSensor services.SessionSensorServiceInterface
+	datum         services.DatumServiceInterface
+	summary       services.SummaryServiceInterface
+	redis         *redis.Client
+	storage       storage.Storage
 }
 
 func NewSessionAPI(
 	sessionService services.SessionServiceInterface,
 	thingService services.ThingServiceInterface,
-	filepath string,
+	sessionSensorService services.SessionSensorServiceInterface,
+	datumService services.DatumServiceInterface,
+	summaryService services.SummaryServiceInterface,
+	redisClient *redis.Client,
+	store storage.Storage,
 ) *SessionHandler {
 	return &SessionHandler{
-		session:  sessionService,
-		thing:    thingService,
-		filepath: filepath,
+		session:       sessionService,
+		thing:         thingService,
+		sessionSensor: sessionSensorService,
+		datum:         datumService,
+		summary:       summaryService,
+		redis:         redisClient,
+		storage:       store,
 	}
 }
 
@@ -115,13 +140,9 @@ func (handler *SessionHandler) GetSessions(ctx *gin.Context) {
 		return
 	}
 
-	// Attempt the file sizes to each session
+	// Attempt the file sizes to each session, archived files count as they are stored
 	for i := range sessions {
-		file := handler.filepath + thingId.String() + "/" + sessions[i].Name + ".csv"
-		fi, err := os.Stat(file)
-		if err == nil {
-			sessions[i].FileSize = fi.Size()
-		}
+		sessions[i].FileSize, _ = storage.FileSize(ctx.Request.Context(), handler.storage, storage.SessionKey(sessions[i]))
 	}
 
 	// Send the response
@@ -173,18 +194,6 @@ func (handler *SessionHandler) UpdateSession(ctx *gin.Context) {
 	updatedSession.ThingId = session.ThingId
 	updatedSession.Generated = session.Generated
 
-	// Attempt to rename the file on the file system if the session name changed
-	if session.Name != updatedSession.Name && session.EndTime != nil {
-		err = os.Rename(
-			handler.filepath+session.ThingId.String()+"/"+session.Name+".csv",
-			handler.filepath+session.ThingId.String()+"/"+updatedSession.Name+".csv",
-		)
-		// if err != nil {
-		// 	utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.FailedToRenameFile))
-		// 	return
-		// }
-	}
-
 	// Attempt to update the collection
 	perr = handler.session.UpdateSession(ctx.Request.Context(), &updatedSession)
 	if perr != nil {
@@ -236,10 +245,13 @@ func (handler *SessionHandler) DeleteSession(ctx *gin.Context) {
 		return
 	}
 
-	// Attempt to delete session file
-	if err = os.Remove(handler.filepath + session.ThingId.String() + "/" + session.Name + ".csv"); err != nil {
-		// utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.FailedToDeleteFile))
-		// return
+	// Attempt to delete the session file and its archive
+	key := storage.SessionKey(session)
+	if err = handler.storage.Delete(ctx.Request.Context(), key); err != nil {
+		log.Println("Failed to delete the file of session " + session.Id.String() + ": " + err.Error())
+	}
+	if err = handler.storage.Delete(ctx.Request.Context(), key+storage.ArchiveExtension); err != nil {
+		log.Println("Failed to delete the archive of session " + session.Id.String() + ": " + err.Error())
 	}
 
 	// Attempt to delete the session
@@ -254,7 +266,6 @@ func (handler *SessionHandler) DeleteSession(ctx *gin.Context) {
 	utils.Response(ctx, http.StatusOK, result)
 }
 
-// TODO: Attempt to insert data
 func (handler *SessionHandler) UploadFile(ctx *gin.Context) {
 	// Guard against non-lead+ requests
 	if !middleware.IsAuthorizationAtLeast(ctx, "Lead") {
@@ -290,6 +301,12 @@ func (handler *SessionHandler) UploadFile(ctx *gin.Context) {
 		return
 	}
 
+	// Guard against sessions that are still being recorded
+	if session.IsLive() {
+		utils.Response(ctx, http.StatusConflict, utils.NewHTTPCustomError(utils.CouldNotUploadFile, "the session is still being recorded"))
+		return
+	}
+
 	// Attempt to read the file
 	file, err := ctx.FormFile("file")
 	if err != nil {
@@ -305,21 +322,82 @@ func (handler *SessionHandler) UploadFile(ctx *gin.Context) {
 		return
 	}
 
-	// Attempt to the directory
-	err = os.MkdirAll(handler.filepath+session.ThingId.String(), 0777)
-	if err != nil && !os.IsExist(err) {
-		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(err.Error()))
+	// Attempt to read the session's sensors
+	sensors, perr := handler.sessionSensor.FindSensorsBySession(ctx.Request.Context(), session)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SensorsNotFound))
 		return
 	}
 
-	// Attempt to save the file
-	if err = ctx.SaveUploadedFile(file, handler.filepath+session.ThingId.String()+"/"+session.Name+".csv"); err != nil {
+	// Attempt to validate the file against the sensors
+	upload, err := file.Open()
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.NoFileRcvd))
+		return
+	}
+	_, err = subscriber.ValidateCsv(upload, sensors)
+	upload.Close()
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.InvalidCsv, err.Error()))
+		return
+	}
+
+	// Attempt to save the file, it is only stored once its data is imported
+	temp, err := os.CreateTemp("", "upload-*.csv")
+	if err != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.CouldNotUploadFile))
+		return
+	}
+	temp.Close()
+	fileName := temp.Name()
+	defer os.Remove(fileName)
+	if err = ctx.SaveUploadedFile(file, fileName); err != nil {
 		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.CouldNotUploadFile))
 		return
 	}
 
+	// Attempt to insert the data
+	saved, err := os.Open(fileName)
+	if err != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.CouldNotUploadFile))
+		return
+	}
+	csvImport, err := subscriber.ImportCsv(ctx.Request.Context(), saved, session, sensors, handler.datum)
+	saved.Close()
+	if err != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPCustomError(utils.CouldNotImportData, err.Error()))
+		return
+	}
+
+	// Attempt to store the file, an archive of an older file is out of date
+	key := storage.SessionKey(session)
+	if err = storage.PutFile(ctx.Request.Context(), handler.storage, key, fileName); err != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.CouldNotUploadFile))
+		return
+	}
+	handler.storage.Delete(ctx.Request.Context(), key+storage.ArchiveExtension)
+
+	// Attempt to end the session with its data if it has no end time
+	if session.EndTime == nil {
+		endTime := session.StartTime + csvImport.LastTimestamp - csvImport.FirstTimestamp
+		if csvImport.FirstTimestamp >= session.StartTime {
+			endTime = csvImport.LastTimestamp
+		}
+		session.EndTime = &endTime
+		if perr = handler.session.UpdateSession(ctx.Request.Context(), session); perr != nil {
+			utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPCustomError(utils.InternalError, perr.Error()))
+			return
+		}
+	}
+
+	// Attempt to summarize the imported data
+	if _, perr = handler.summary.Compute(ctx.Request.Context(), session.Id); perr != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPCustomError(utils.InternalError, perr.Error()))
+		return
+	}
+
 	// Send the response
-	result := utils.SuccessPayload(nil, "Successfully uploaded file")
+	result := utils.SuccessPayload(csvImport, "Successfully uploaded file")
 	utils.Response(ctx, http.StatusOK, result)
 }
 
@@ -358,22 +436,308 @@ func (handler *SessionHandler) DownloadFile(ctx *gin.Context) {
 		return
 	}
 
-	// Attempt to read the file
-	file, err := os.Open(handler.filepath + session.ThingId.String() + "/" + session.Name + ".csv")
+	// Generate the other formats and filled data from the stored data, the
+	// stored csv only has the samples that were sent
+	fill, err := parseFill(ctx)
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+	if format := ctx.DefaultQuery("format", export.FormatCsv); format != export.FormatCsv || fill != services.FillNone {
+		handler.exportSession(ctx, session, thing, format, fill)
+		return
+	}
+
+	// Attempt to read the file, archived files are sent as they are stored
+	key := storage.SessionKey(session)
+	file, err := handler.storage.Open(ctx.Request.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		handler.sendArchive(ctx, session, key+storage.ArchiveExtension)
+		return
+	}
 	if err != nil {
 		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.FileNotFound))
 		return
 	}
 	defer file.Close()
+	info := file.Info()
+
+	// The validator lets interrupted downloads resume with If-Range
+	etag := "\"" + strconv.FormatInt(info.Size, 16) + "-" + strconv.FormatInt(info.ModTime.UnixNano(), 16) + "\""
+	ctx.Header("Content-Disposition", "attachment; filename=\""+session.Name+".csv\"")
+	ctx.Header("Content-Type", "text/csv")
+	ctx.Header("Vary", "Accept-Encoding")
+
+	// Compress whole downloads, ranges are served from the file as is. The
+	// compressed bytes cannot be resumed from, so the response has no date to
+	// resume with and a weak validator that If-Range never matches.
+	if ctx.GetHeader("Range") == "" && acceptsGzip(ctx.GetHeader("Accept-Encoding")) {
+		ctx.Header("Content-Encoding", "gzip")
+		ctx.Header("ETag", "W/"+etag)
+		ctx.Header("Accept-Ranges", "none")
+		ctx.Status(http.StatusOK)
+		writer := gzip.NewWriter(ctx.Writer)
+		if _, err = io.Copy(writer, file); err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			log.Println("Failed to send session " + session.Id.String() + ": " + err.Error())
+		}
+		return
+	}
+
+	// Send the response, ranges and conditional requests are handled here
+	ctx.Header("ETag", etag)
+	http.ServeContent(ctx.Writer, ctx.Request, session.Name+".csv", info.ModTime, file)
+}
+
+// Sends a gzipped archive as is to clients that accept gzip, it is
+// decompressed for the others. Ranges are not supported.
+func (handler *SessionHandler) sendArchive(ctx *gin.Context, session *model.Session, archiveKey string) {
+	archive, err := handler.storage.Open(ctx.Request.Context(), archiveKey)
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.FileNotFound))
+		return
+	}
+	defer archive.Close()
+
+	ctx.Header("Content-Disposition", "attachment; filename=\""+session.Name+".csv\"")
+	ctx.Header("Content-Type", "text/csv")
+	ctx.Header("Vary", "Accept-Encoding")
+	ctx.Header("Accept-Ranges", "none")
+	var reader io.Reader = archive
+	if acceptsGzip(ctx.GetHeader("Accept-Encoding")) {
+		ctx.Header("Content-Encoding", "gzip")
+	} else {
+		gzipReader, err := gzip.NewReader(archive)
+		if err != nil {
+			utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.FileNotFound))
+			return
+		}
+		reader = gzipReader
+	}
+	ctx.Status(http.StatusOK)
+	if _, err = io.Copy(ctx.Writer, reader); err != nil {
+		log.Println("Failed to send session " + session.Id.String() + ": " + err.Error())
+	}
+}
+
+// Checks if a client accepts gzip content encoding
+func acceptsGzip(acceptEncoding string) bool {
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(encoding, ";")
+		if name := strings.TrimSpace(parts[0]); name != "gzip" && name != "*" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				if weight, err := strconv.ParseFloat(q[2:], 64); err == nil && weight == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Generates a session's file in a download format from its stored data
+func (handler *SessionHandler) exportSession(ctx *gin.Context, session *model.Session, thing *model.Thing, name string, fill string) {
+	format, ok := export.FindFormat(name)
+	if !ok {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.UnsupportedFormat))
+		return
+	}
+
+	// Attempt to read the sensors and their data
+	sensors, perr := handler.sessionSensor.FindSensorsBySession(ctx.Request.Context(), session)
+	if perr != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.SensorsNotFound))
+		return
+	}
+	sensorIds := make([]uuid.UUID, len(sensors))
+	for i, sensor := range sensors {
+		sensorIds[i] = sensor.Id
+	}
+	aligned, perr := handler.datum.FindAlignedBySessionId(ctx, session.Id, sensorIds, &services.DatumQuery{Fill: fill})
+	if perr != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(utils.CouldNotExportData))
+		return
+	}
+
+	// Send the response, the status is already sent if writing fails part way
+	ctx.Header("Content-Disposition", "attachment; filename=\""+session.Name+format.Extension+"\"")
+	ctx.Header("Content-Type", format.ContentType)
+	ctx.Status(http.StatusOK)
+	if err := format.Write(ctx.Writer, export.NewTable(session, thing, sensors, aligned)); err != nil {
+		log.Println("Failed to export session " + session.Id.String() + ": " + err.Error())
+	}
+}
+
+func (handler *SessionHandler) GetSummary(ctx *gin.Context) {
+	// Attempt to read from the params
+	sessionId, err := uuid.Parse(ctx.Param("sessionId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+
+	// Attempt to read the session
+	session, perr := handler.session.FindById(ctx.Request.Context(), sessionId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SessionNotFound))
+		return
+	}
 
-	// Attempt to place the data into a buffer
-	buf := &bytes.Buffer{}
-	nRead, err := io.Copy(buf, file)
+	// Attempt to read the thing
+	thing, perr := handler.thing.FindById(ctx.Request.Context(), session.ThingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return
+	}
+
+	// Guard against cross-tenant reads
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to read the summary, ended sessions from before summaries
+	// existed are summarized now
+	summaries, perr := handler.summary.FindBySessionId(ctx.Request.Context(), sessionId)
+	if perr == nil && len(summaries) == 0 && session.EndTime != nil {
+		summaries, perr = handler.summary.Compute(ctx.Request.Context(), sessionId)
+	}
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SummaryNotFound))
+		return
+	}
+
+	// Send the response
+	result := utils.SuccessPayload(summaries, "Successfully retrieved summary.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+func (handler *SessionHandler) GetSessionSensors(ctx *gin.Context) {
+	// Attempt to read from the params
+	sessionId, err := uuid.Parse(ctx.Param("sessionId"))
 	if err != nil {
-		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPError(err.Error()))
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+
+	// Attempt to read the session
+	session, perr := handler.session.FindById(ctx.Request.Context(), sessionId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SessionNotFound))
+		return
+	}
+
+	// Attempt to read the thing
+	thing, perr := handler.thing.FindById(ctx.Request.Context(), session.ThingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return
+	}
+
+	// Guard against cross-tenant reads
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Attempt to read the sensors the session was recorded with
+	sensors, perr := handler.sessionSensor.FindSensorsBySession(ctx.Request.Context(), session)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SensorsNotFound))
 		return
 	}
 
 	// Send the response
-	ctx.DataFromReader(http.StatusOK, nRead, "text/csv", buf, nil)
+	result := utils.SuccessPayload(sensors, "Successfully retrieved the session's sensors.")
+	utils.Response(ctx, http.StatusOK, result)
+}
+
+func (handler *SessionHandler) StreamLiveData(ctx *gin.Context) {
+	// Attempt to read from the params
+	sessionId, err := uuid.Parse(ctx.Param("sessionId"))
+	if err != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPCustomError(utils.BadRequest, err.Error()))
+		return
+	}
+
+	// Attempt to read the session
+	session, perr := handler.session.FindById(ctx, sessionId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SessionNotFound))
+		return
+	}
+
+	// Attempt to read the thing
+	thing, perr := handler.thing.FindById(ctx, session.ThingId)
+	if perr != nil {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.ThingNotFound))
+		return
+	}
+
+	// Guard against cross-tenant reads
+	organization, _ := middleware.GetOrganizationClaim(ctx)
+	if thing.OrganizationId != organization.Id {
+		utils.Response(ctx, http.StatusUnauthorized, utils.NewHTTPError(utils.Unauthorized))
+		return
+	}
+
+	// Guard against sessions that are not being recorded
+	if !session.IsLive() {
+		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.SessionNotLive))
+		return
+	}
+
+	// Subscribe to the decoded session data
+	pubsub := handler.redis.Subscribe(ctx.Request.Context(), subscriber.LiveChannel(sessionId))
+	defer pubsub.Close()
+	if _, err = pubsub.Receive(ctx.Request.Context()); err != nil {
+		utils.Response(ctx, http.StatusInternalServerError, utils.NewHTTPCustomError(utils.InternalError, err.Error()))
+		return
+	}
+	liveChannel := pubsub.Channel()
+
+	// Check the session again now that no event can be missed, it may have
+	// ended before the subscription
+	session, perr = handler.session.FindById(ctx, sessionId)
+	ended := perr != nil || !session.IsLive()
+
+	// Stream the events until the session ends or the client disconnects
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+	if ended {
+		ctx.SSEvent(subscriber.LiveEventEnd, json.RawMessage("null"))
+		return
+	}
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Request.Context().Done():
+			return false
+		case <-time.After(liveKeepAliveInterval):
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case msg, open := <-liveChannel:
+			if !open {
+				return false
+			}
+			var event struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				return true
+			}
+			ctx.SSEvent(event.Type, event.Data)
+			return event.Type != subscriber.LiveEventEnd
+		}
+	})
 }
@@ -4,21 +4,21 @@ import (
 	"database-ms/app/middleware"
 	"database-ms/app/model"
 	services "database-ms/app/services"
+	"database-ms/app/storage"
 	utils "database-ms/app/utils"
 	"net/http"
-	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 type ThingHandler struct {
-	service  services.ThingServiceInterface
-	filepath string
+	service services.ThingServiceInterface
+	storage storage.Storage
 }
 
-func NewThingAPI(thingService services.ThingServiceInterface, filepath string) *ThingHandler {
-	return &ThingHandler{service: thingService, filepath: filepath}
+func NewThingAPI(thingService services.ThingServiceInterface, store storage.Storage) *ThingHandler {
+	return &ThingHandler{service: thingService, storage: store}
 }
 
 func (handler *ThingHandler) CreateThing(ctx *gin.Context) {
@@ -150,7 +150,7 @@ func (handler *ThingHandler) DeleteThing(ctx *gin.Context) {
 	}
 
 	// Attempt to delete session files related to the thing
-	if err = os.RemoveAll(handler.filepath + thingIdToDelete.String()); err != nil {
+	if err = handler.storage.DeletePrefix(ctx.Request.Context(), storage.ThingPrefix(thingIdToDelete.String())); err != nil {
 		utils.Response(ctx, http.StatusBadRequest, utils.NewHTTPError(utils.FailedToDeleteFiles))
 		return
 	}
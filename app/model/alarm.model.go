@@ -0,0 +1,37 @@
+package model
+
+import "github.com/google/uuid"
+
+const TableNameAlarm = "alarm"
+
+// Alarm levels, danger takes precedence over warning
+const (
+	AlarmLevelWarning = "warning"
+	AlarmLevelDanger  = "danger"
+)
+
+// Sides of a sensor's range an alarm is on
+const (
+	AlarmDirectionUpper = "upper"
+	AlarmDirectionLower = "lower"
+)
+
+// Alarm is a period where a sensor was past its warning or danger threshold
+// during a session. The end time is nil while the alarm is active and the
+// peak is the furthest value past the threshold.
+type Alarm struct {
+	Base
+	SessionId uuid.UUID `gorm:"type:uuid;column:session_id;not null;index" json:"sessionId"`
+	SensorId  uuid.UUID `gorm:"type:uuid;column:sensor_id;not null" json:"sensorId"`
+	Level     string    `gorm:"column:level;not null" json:"level"`
+	Direction string    `gorm:"column:direction;not null" json:"direction"`
+	Threshold float64   `gorm:"column:threshold;not null" json:"threshold"`
+	StartTime int64     `gorm:"column:start_time;not null" json:"startTime"`
+	EndTime   *int64    `gorm:"column:end_time" json:"endTime,omitempty"`
+	Peak      float64   `gorm:"column:peak;not null" json:"peak"`
+	Session   Session   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+func (*Alarm) TableName() string {
+	return TableNameAlarm
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const TableNameSensorCalibration = "sensor_calibration"
+
+// SensorCalibration is a version of the conversion from a sensor's raw values
+// to engineering units. A version is added whenever a sensor is created or
+// its calibration changes, and applies from its ValidFrom time.
+type SensorCalibration struct {
+	Base
+	SensorId             uuid.UUID `gorm:"type:uuid;column:sensor_id;not null;uniqueIndex:unique_calibration_version_of_sensor" json:"sensorId"`
+	Version              int       `gorm:"column:version;not null;uniqueIndex:unique_calibration_version_of_sensor" json:"version"`
+	ValidFrom            int64     `gorm:"column:valid_from;not null" json:"validFrom"`
+	ConversionMultiplier float64   `gorm:"column:conversion_multiplier" json:"conversionMultiplier"`
+	LowerCalibration     float64   `gorm:"column:lower_calibration" json:"lowerCalibration"`
+	UpperCalibration     float64   `gorm:"column:upper_calibration" json:"upperCalibration"`
+	LowerBound           float64   `gorm:"column:lower_bound" json:"lowerBound"`
+	UpperBound           float64   `gorm:"column:upper_bound" json:"upperBound"`
+	Sensor               Sensor    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+func (*SensorCalibration) TableName() string {
+	return TableNameSensorCalibration
+}
+
+// CalibrationOf is the calibration currently set on a sensor
+func CalibrationOf(sensor *Sensor) *SensorCalibration {
+	return &SensorCalibration{
+		SensorId:             sensor.Id,
+		ValidFrom:            sensor.LastUpdate,
+		ConversionMultiplier: sensor.ConversionMultiplier,
+		LowerCalibration:     sensor.LowerCalibration,
+		UpperCalibration:     sensor.UpperCalibration,
+		LowerBound:           sensor.LowerBound,
+		UpperBound:           sensor.UpperBound,
+	}
+}
+
+// Apply converts a raw value. The value is scaled by the conversion
+// multiplier when it is set, then mapped linearly from the calibration range
+// onto the sensor's bounds when both ranges are set.
+func (c *SensorCalibration) Apply(raw float64) float64 {
+	value := raw
+	if c.ConversionMultiplier != 0 {
+		value *= c.ConversionMultiplier
+	}
+	if c.UpperCalibration > c.LowerCalibration && c.UpperBound > c.LowerBound {
+		value = c.LowerBound + (value-c.LowerCalibration)*(c.UpperBound-c.LowerBound)/(c.UpperCalibration-c.LowerCalibration)
+	}
+	return value
+}
+
+// Equal tells if two calibrations convert values alike
+func (c *SensorCalibration) Equal(other *SensorCalibration) bool {
+	return c.ConversionMultiplier == other.ConversionMultiplier &&
+		c.LowerCalibration == other.LowerCalibration &&
+		c.UpperCalibration == other.UpperCalibration &&
+		c.LowerBound == other.LowerBound &&
+		c.UpperBound == other.UpperBound
+}
+
+// Adds a calibration version when a sensor is created or recalibrated
+func (s *Sensor) AfterSave(db *gorm.DB) error {
+	if s.IsDerived() {
+		return nil
+	}
+	var latest []*SensorCalibration
+	result := db.Session(&gorm.Session{NewDB: true}).
+		Where("sensor_id = ?", s.Id).Order("version desc").Limit(1).Find(&latest)
+	if result.Error != nil {
+		return result.Error
+	}
+	calibration := CalibrationOf(s)
+	calibration.Version = 1
+	if len(latest) > 0 {
+		if latest[0].Equal(calibration) {
+			return nil
+		}
+		calibration.Version = latest[0].Version + 1
+	}
+	return db.Session(&gorm.Session{NewDB: true}).Create(calibration).Error
+}
@@ -1,16 +1,28 @@
 package model
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"github.com/jackc/pgtype"
+)
 
-const TableNameDatum = "datum"
+const (
+	TableNameDatum       = "datum"
+	TableNameDatumChunk  = "datum_chunk"
+	TableNameDatumSample = "datum_sample"
+)
 
+// Most samples packed in a single datum chunk
+const DatumChunkSize = 1024
+
+// Datum is a single sample of a sensor. Samples are stored packed in datum
+// chunks, the datum table only holds the samples written before chunks
+// existed until they are packed by the migration.
 type Datum struct {
 	Base
-	Timestamp int64     `gorm:"column:timestamp;not null"`
+	Timestamp int64     `gorm:"column:timestamp;not null;index:idx_datum_window,priority:3"`
 	Value     float64   `gorm:"column:value;not null"`
-	SensorId  uuid.UUID `gorm:"column:sensor_id;not null"`
-	SessionId uuid.UUID `gorm:"column:session_id;not null"`
-	Sensor    Sensor    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	SensorId  uuid.UUID `gorm:"column:sensor_id;not null;index:idx_datum_window,priority:2"`
+	SessionId uuid.UUID `gorm:"column:session_id;not null;index:idx_datum_window,priority:1"`
 	Session   Session   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
 
@@ -22,3 +34,70 @@ type Data struct {
 func (*Datum) TableName() string {
 	return TableNameDatum
 }
+
+// DatumChunk packs up to DatumChunkSize consecutive samples of a sensor in a
+// session, ordered by timestamp. Chunks of a sensor do not overlap once the
+// session is compacted.
+type DatumChunk struct {
+	Id               int64              `gorm:"column:id;primaryKey;autoIncrement"`
+	SessionId        uuid.UUID          `gorm:"type:uuid;column:session_id;not null;index:idx_datum_chunk_window,priority:1"`
+	SensorId         uuid.UUID          `gorm:"type:uuid;column:sensor_id;not null;index:idx_datum_chunk_window,priority:2"`
+	FirstTimestamp   int64              `gorm:"column:first_timestamp;not null;index:idx_datum_chunk_window,priority:3"`
+	LastTimestamp    int64              `gorm:"column:last_timestamp;not null"`
+	Count            int                `gorm:"column:count;not null"`
+	SampleTimestamps pgtype.Int8Array   `gorm:"type:bigint[];column:sample_timestamps;not null"`
+	SampleValues     pgtype.Float8Array `gorm:"type:double precision[];column:sample_values;not null"`
+	Session          Session            `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+func (*DatumChunk) TableName() string {
+	return TableNameDatumChunk
+}
+
+// DatumSampleView unpacks the chunks into one row per sample, followed by
+// the samples of the datum table. The chunk's bounds are kept so that
+// queries on a window can skip whole chunks.
+const DatumSampleView = `CREATE OR REPLACE VIEW ` + TableNameDatumSample + ` AS
+SELECT chunk.session_id, chunk.sensor_id,
+	chunk.first_timestamp AS chunk_first, chunk.last_timestamp AS chunk_last,
+	sample.timestamp, sample.value
+FROM ` + TableNameDatumChunk + ` chunk,
+	UNNEST(chunk.sample_timestamps, chunk.sample_values) AS sample(timestamp, value)
+UNION ALL
+SELECT session_id, sensor_id, timestamp AS chunk_first, timestamp AS chunk_last, timestamp, value
+FROM ` + TableNameDatum
+
+// NewDatumChunks packs a sensor's samples, which must be sorted by timestamp
+func NewDatumChunks(sessionId uuid.UUID, sensorId uuid.UUID, timestamps []int64, values []float64) []*DatumChunk {
+	var chunks []*DatumChunk
+	for start := 0; start < len(timestamps); start += DatumChunkSize {
+		end := start + DatumChunkSize
+		if end > len(timestamps) {
+			end = len(timestamps)
+		}
+		chunk := &DatumChunk{
+			SessionId:      sessionId,
+			SensorId:       sensorId,
+			FirstTimestamp: timestamps[start],
+			LastTimestamp:  timestamps[end-1],
+			Count:          end - start,
+		}
+		chunk.SampleTimestamps.Set(timestamps[start:end])
+		chunk.SampleValues.Set(values[start:end])
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// Samples unpacks the chunk
+func (c *DatumChunk) Samples() ([]int64, []float64) {
+	timestamps := make([]int64, 0, len(c.SampleTimestamps.Elements))
+	for _, element := range c.SampleTimestamps.Elements {
+		timestamps = append(timestamps, element.Int)
+	}
+	values := make([]float64, 0, len(c.SampleValues.Elements))
+	for _, element := range c.SampleValues.Elements {
+		values = append(values, element.Float)
+	}
+	return timestamps, values
+}
@@ -0,0 +1,21 @@
+package model
+
+import "github.com/google/uuid"
+
+const TableNameDeadLetter = "dead_letter"
+
+// DeadLetter keeps a payload that could not be ingested. It has no foreign
+// keys so that it outlives unknown or deleted things and sessions.
+type DeadLetter struct {
+	Base
+	Kind      string     `gorm:"column:kind;not null" json:"kind"`
+	Error     string     `gorm:"column:error;not null" json:"error"`
+	Payload   string     `gorm:"column:payload;not null" json:"payload"`
+	Time      int64      `gorm:"column:time;not null" json:"time"`
+	ThingId   *uuid.UUID `gorm:"type:uuid;column:thing_id" json:"thingId,omitempty"`
+	SessionId *uuid.UUID `gorm:"type:uuid;column:session_id" json:"sessionId,omitempty"`
+}
+
+func (*DeadLetter) TableName() string {
+	return TableNameDeadLetter
+}
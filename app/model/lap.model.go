@@ -0,0 +1,29 @@
+package model
+
+import "github.com/google/uuid"
+
+const TableNameLap = "lap"
+
+// Ways the laps of a session are found
+const (
+	LapSourceBeacon = "beacon"
+	LapSourceGps    = "gps"
+	LapSourceManual = "manual"
+)
+
+// Lap is the part of a session between two crossings of the start/finish
+// line, the laps of a session are numbered from 1
+type Lap struct {
+	Base
+	SessionId uuid.UUID `gorm:"type:uuid;column:session_id;not null;uniqueIndex:unique_lap_number_in_session" json:"sessionId"`
+	Number    int       `gorm:"column:number;not null;uniqueIndex:unique_lap_number_in_session" json:"number"`
+	StartTime int64     `gorm:"column:start_time;not null" json:"startTime"`
+	EndTime   int64     `gorm:"column:end_time;not null" json:"endTime"`
+	LapTime   int64     `gorm:"column:lap_time;not null" json:"lapTime"`
+	Source    string    `gorm:"column:source;not null" json:"source"`
+	Session   Session   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+func (*Lap) TableName() string {
+	return TableNameLap
+}
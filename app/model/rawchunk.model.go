@@ -0,0 +1,22 @@
+package model
+
+import "github.com/google/uuid"
+
+const TableNameRawChunk = "raw_chunk"
+
+// RawChunk keeps a chunk of a session's data as the thing sent it, before
+// calibration and derived sensors, so the session can be processed again.
+// The payload is a JSON array of samples keyed by small id.
+type RawChunk struct {
+	Base
+	SessionId      uuid.UUID `gorm:"type:uuid;column:session_id;not null;index" json:"sessionId"`
+	FirstTimestamp int64     `gorm:"column:first_timestamp;not null" json:"firstTimestamp"`
+	LastTimestamp  int64     `gorm:"column:last_timestamp;not null" json:"lastTimestamp"`
+	Time           int64     `gorm:"column:time;not null" json:"time"`
+	Payload        string    `gorm:"column:payload;not null" json:"payload"`
+	Session        Session   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+func (*RawChunk) TableName() string {
+	return TableNameRawChunk
+}
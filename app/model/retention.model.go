@@ -0,0 +1,33 @@
+package model
+
+import "github.com/google/uuid"
+
+const TableNameRetentionPolicy = "retention_policy"
+
+// RetentionPolicy sets how many days after a session ends its data is kept.
+// Sensor data and raw data are deleted, the csv file is first compressed
+// into an archive and then deleted. Durations left empty keep the data
+// forever. Summaries, laps and alarms are always kept.
+type RetentionPolicy struct {
+	Base
+	OrganizationId uuid.UUID    `gorm:"type:uuid;column:organization_id;not null;unique" json:"organizationId"`
+	DatumDays      *int         `gorm:"column:datum_days" json:"datumDays"`
+	RawDataDays    *int         `gorm:"column:raw_data_days" json:"rawDataDays"`
+	ArchiveDays    *int         `gorm:"column:archive_days" json:"archiveDays"`
+	FileDays       *int         `gorm:"column:file_days" json:"fileDays"`
+	Organization   Organization `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+func (*RetentionPolicy) TableName() string {
+	return TableNameRetentionPolicy
+}
+
+// Checks that the durations that are set are at least a day
+func (p *RetentionPolicy) IsValid() bool {
+	for _, days := range []*int{p.DatumDays, p.RawDataDays, p.ArchiveDays, p.FileDays} {
+		if days != nil && *days < 1 {
+			return false
+		}
+	}
+	return true
+}
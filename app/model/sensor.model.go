@@ -7,6 +7,14 @@ import (
 
 const TableNameSensor = "sensor"
 
+// Derived sensors are not sent by the thing, their values are computed from
+// the other sensors of the thing with their expression
+const SensorTypeDerived = "x"
+
+// Sensors sent as 64 bit floats, "d" is the python struct type character of
+// a double
+const SensorTypeDouble = "d"
+
 type LastUpdateSensors struct {
 	Sensors   []Sensor    `json:"sensors"`
 	SensorIds []uuid.UUID `json:"existingSensorIds"`
@@ -32,6 +40,7 @@ type Sensor struct {
 	LowerDanger          float64   `gorm:"column:lower_danger" json:"lowerDanger,omitempty"`
 	UpperBound           float64   `gorm:"column:upper_bound;not null" json:"upperBound"`
 	LowerBound           float64   `gorm:"column:lower_bound;not null" json:"lowerBound"`
+	Expression           string    `gorm:"column:expression" json:"expression,omitempty"`
 	Thing                Thing     `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
 }
 
@@ -39,6 +48,10 @@ func (*Sensor) TableName() string {
 	return TableNameSensor
 }
 
+func (s *Sensor) IsDerived() bool {
+	return s.Type == SensorTypeDerived
+}
+
 func (s *Sensor) BeforeDelete(db *gorm.DB) (err error) {
 	err = db.Transaction(func(db *gorm.DB) error {
 		// Delete the raw data preset entries
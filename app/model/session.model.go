@@ -19,13 +19,27 @@ type Session struct {
 	Operator      Operator    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
 	CollectionIds []uuid.UUID `gorm:"-" json:"collectionIds"`
 	FileSize      int64       `gorm:"-" json:"fileSize,omitempty"`
+
+	// Number of entries of the thing's redis list that were written, counted
+	// from the first entry ever pushed. Kept with the data so that entries
+	// are never written twice.
+	FlushedEntries int64 `gorm:"column:flushed_entries;not null;default:0" json:"-"`
 }
 
 func (*Session) TableName() string {
 	return TableNameSession
 }
 
+// IsLive tells if a generated session is still being recorded, its data and
+// file are being written
+func (s *Session) IsLive() bool {
+	return s.EndTime == nil && s.Generated != nil && *s.Generated
+}
+
 func (s *Session) AfterCreate(db *gorm.DB) (err error) {
+	if err = SnapshotSessionSensors(s, db); err != nil {
+		return err
+	}
 	return InsertSessionCollections(s, db)
 }
 
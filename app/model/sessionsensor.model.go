@@ -0,0 +1,108 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const TableNameSessionSensor = "session_sensor"
+
+// SessionSensor is a copy of a sensor's configuration taken when a session
+// starts, so the session's data keeps the names, units and calibration it
+// was recorded with. It has no foreign key to the sensor so it outlives it.
+type SessionSensor struct {
+	Base
+	SessionId            uuid.UUID `gorm:"type:uuid;column:session_id;not null;uniqueIndex:unique_sensor_in_session" json:"sessionId"`
+	SensorId             uuid.UUID `gorm:"type:uuid;column:sensor_id;not null;uniqueIndex:unique_sensor_in_session" json:"sensorId"`
+	SmallId              int       `gorm:"column:small_id;not null" json:"smallId"`
+	Type                 string    `gorm:"type:varchar(1);column:type;not null" json:"type"`
+	LastUpdate           int64     `gorm:"column:last_update;not null" json:"lastUpdate"`
+	Name                 string    `gorm:"column:name;not null" json:"name"`
+	Frequency            int32     `gorm:"column:frequency;not null" json:"frequency"`
+	Unit                 string    `gorm:"column:unit" json:"unit,omitempty"`
+	CanId                int64     `gorm:"column:can_id;not null" json:"canId"`
+	CanOffset            int       `gorm:"column:can_offset;not null" json:"canOffset"`
+	UpperCalibration     float64   `gorm:"column:upper_calibration" json:"upperCalibration,omitempty"`
+	LowerCalibration     float64   `gorm:"column:lower_calibration" json:"lowerCalibration,omitempty"`
+	ConversionMultiplier float64   `gorm:"column:conversion_multiplier" json:"conversionMultiplier,omitempty"`
+	UpperWarning         float64   `gorm:"column:upper_warning" json:"upperWarning,omitempty"`
+	LowerWarning         float64   `gorm:"column:lower_warning" json:"lowerWarning,omitempty"`
+	UpperDanger          float64   `gorm:"column:upper_danger" json:"upperDanger,omitempty"`
+	LowerDanger          float64   `gorm:"column:lower_danger" json:"lowerDanger,omitempty"`
+	UpperBound           float64   `gorm:"column:upper_bound;not null" json:"upperBound"`
+	LowerBound           float64   `gorm:"column:lower_bound;not null" json:"lowerBound"`
+	Expression           string    `gorm:"column:expression" json:"expression,omitempty"`
+	Session              Session   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+func (*SessionSensor) TableName() string {
+	return TableNameSessionSensor
+}
+
+func NewSessionSensor(sessionId uuid.UUID, sensor *Sensor) *SessionSensor {
+	return &SessionSensor{
+		SessionId:            sessionId,
+		SensorId:             sensor.Id,
+		SmallId:              sensor.SmallId,
+		Type:                 sensor.Type,
+		LastUpdate:           sensor.LastUpdate,
+		Name:                 sensor.Name,
+		Frequency:            sensor.Frequency,
+		Unit:                 sensor.Unit,
+		CanId:                sensor.CanId,
+		CanOffset:            sensor.CanOffset,
+		UpperCalibration:     sensor.UpperCalibration,
+		LowerCalibration:     sensor.LowerCalibration,
+		ConversionMultiplier: sensor.ConversionMultiplier,
+		UpperWarning:         sensor.UpperWarning,
+		LowerWarning:         sensor.LowerWarning,
+		UpperDanger:          sensor.UpperDanger,
+		LowerDanger:          sensor.LowerDanger,
+		UpperBound:           sensor.UpperBound,
+		LowerBound:           sensor.LowerBound,
+		Expression:           sensor.Expression,
+	}
+}
+
+// Sensor is the snapshot as a sensor of the thing, with the sensor's id
+func (s *SessionSensor) Sensor(thingId uuid.UUID) *Sensor {
+	return &Sensor{
+		Base:                 Base{Id: s.SensorId},
+		SmallId:              s.SmallId,
+		Type:                 s.Type,
+		LastUpdate:           s.LastUpdate,
+		Name:                 s.Name,
+		Frequency:            s.Frequency,
+		Unit:                 s.Unit,
+		CanId:                s.CanId,
+		CanOffset:            s.CanOffset,
+		ThingId:              thingId,
+		UpperCalibration:     s.UpperCalibration,
+		LowerCalibration:     s.LowerCalibration,
+		ConversionMultiplier: s.ConversionMultiplier,
+		UpperWarning:         s.UpperWarning,
+		LowerWarning:         s.LowerWarning,
+		UpperDanger:          s.UpperDanger,
+		LowerDanger:          s.LowerDanger,
+		UpperBound:           s.UpperBound,
+		LowerBound:           s.LowerBound,
+		Expression:           s.Expression,
+	}
+}
+
+// Copies the thing's sensors for a session
+func SnapshotSessionSensors(s *Session, db *gorm.DB) error {
+	var sensors []*Sensor
+	result := db.Session(&gorm.Session{NewDB: true}).Where("thing_id = ?", s.ThingId).Find(&sensors)
+	if result.Error != nil {
+		return result.Error
+	}
+	if len(sensors) == 0 {
+		return nil
+	}
+	snapshot := make([]*SessionSensor, 0, len(sensors))
+	for _, sensor := range sensors {
+		snapshot = append(snapshot, NewSessionSensor(s.Id, sensor))
+	}
+	return db.Session(&gorm.Session{NewDB: true}).CreateInBatches(snapshot, 100).Error
+}
@@ -0,0 +1,33 @@
+package model
+
+import "github.com/google/uuid"
+
+const TableNameSensorSummary = "sensor_summary"
+
+// SensorSummary holds the statistics of a sensor's data in a session. The
+// times past the warning and danger thresholds are in milliseconds, time in
+// danger is not counted as time in warning.
+type SensorSummary struct {
+	Base
+	SessionId     uuid.UUID `gorm:"type:uuid;column:session_id;not null;uniqueIndex:unique_summary_sensor_in_session" json:"sessionId"`
+	SensorId      uuid.UUID `gorm:"type:uuid;column:sensor_id;not null;uniqueIndex:unique_summary_sensor_in_session" json:"sensorId"`
+	Count         int64     `gorm:"column:count;not null" json:"count"`
+	Min           float64   `gorm:"column:min;not null" json:"min"`
+	Max           float64   `gorm:"column:max;not null" json:"max"`
+	Mean          float64   `gorm:"column:mean;not null" json:"mean"`
+	Stddev        float64   `gorm:"column:stddev;not null" json:"stddev"`
+	P1            float64   `gorm:"column:p1;not null" json:"p1"`
+	P5            float64   `gorm:"column:p5;not null" json:"p5"`
+	P25           float64   `gorm:"column:p25;not null" json:"p25"`
+	P50           float64   `gorm:"column:p50;not null" json:"p50"`
+	P75           float64   `gorm:"column:p75;not null" json:"p75"`
+	P95           float64   `gorm:"column:p95;not null" json:"p95"`
+	P99           float64   `gorm:"column:p99;not null" json:"p99"`
+	TimeInWarning int64     `gorm:"column:time_in_warning;not null" json:"timeInWarning"`
+	TimeInDanger  int64     `gorm:"column:time_in_danger;not null" json:"timeInDanger"`
+	Session       Session   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+func (*SensorSummary) TableName() string {
+	return TableNameSensorSummary
+}
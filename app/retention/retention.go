@@ -0,0 +1,192 @@
+package retention
+
+import (
+	"compress/gzip"
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"database-ms/app/storage"
+	"database-ms/config"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const day = int64(24 * time.Hour / time.Millisecond)
+
+// Enforcer applies the organizations' retention policies to their ended
+// sessions in the background
+type Enforcer struct {
+	conf      *config.Configuration
+	storage   storage.Storage
+	retention services.RetentionServiceInterface
+	summary   services.SummaryServiceInterface
+	datum     services.DatumServiceInterface
+	rawChunk  services.RawChunkServiceInterface
+}
+
+func NewEnforcer(db *gorm.DB, conf *config.Configuration) *Enforcer {
+	return &Enforcer{
+		conf:      conf,
+		storage:   storage.NewStorage(conf),
+		retention: services.NewRetentionService(db, conf),
+		summary:   services.NewSummaryService(db, conf),
+		datum:     services.NewDatumService(db, conf),
+		rawChunk:  services.NewRawChunkService(db, conf),
+	}
+}
+
+// Start enforces the policies now and then at every retention interval
+func (enforcer *Enforcer) Start() {
+	if enforcer.conf.RetentionInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(enforcer.conf.RetentionInterval) * time.Minute)
+		defer ticker.Stop()
+		for {
+			enforcer.Enforce(context.Background())
+			<-ticker.C
+		}
+	}()
+}
+
+// Enforce applies every policy once, a policy that fails is retried on the
+// next run
+func (enforcer *Enforcer) Enforce(ctx context.Context) {
+	policies, perr := enforcer.retention.FindAll(ctx)
+	if perr != nil {
+		log.Println("Failed to read retention policies: " + perr.Error())
+		return
+	}
+	now := time.Now().UnixMilli()
+	for _, policy := range policies {
+		if err := enforcer.enforce(ctx, policy, now); err != nil {
+			log.Println("Failed to enforce the retention policy of organization " + policy.OrganizationId.String() + ": " + err.Error())
+		}
+	}
+}
+
+func (enforcer *Enforcer) enforce(ctx context.Context, policy *model.RetentionPolicy, now int64) error {
+	// Delete the sensor data, making sure the summary is kept
+	if policy.DatumDays != nil {
+		sessions, perr := enforcer.retention.FindEndedSessions(ctx, policy.OrganizationId, now-int64(*policy.DatumDays)*day,
+			model.TableNameDatumChunk, model.TableNameDatum)
+		if perr != nil {
+			return perr
+		}
+		for _, session := range sessions {
+			summaries, perr := enforcer.summary.FindBySessionId(ctx, session.Id)
+			if perr == nil && len(summaries) == 0 {
+				summaries, perr = enforcer.summary.Compute(ctx, session.Id)
+			}
+			if perr != nil {
+				return perr
+			}
+
+			// Guard against deleting data that could not be summarized
+			if len(summaries) == 0 {
+				log.Println("Kept the sensor data of session " + session.Id.String() + ", it has no summary")
+				continue
+			}
+			if perr = enforcer.datum.DeleteBySessionId(ctx, session.Id); perr != nil {
+				return perr
+			}
+			log.Println("Deleted the sensor data of session " + session.Id.String())
+		}
+	}
+
+	// Delete the raw data
+	if policy.RawDataDays != nil {
+		sessions, perr := enforcer.retention.FindEndedSessions(ctx, policy.OrganizationId, now-int64(*policy.RawDataDays)*day,
+			model.TableNameRawChunk)
+		if perr != nil {
+			return perr
+		}
+		for _, session := range sessions {
+			if perr = enforcer.rawChunk.DeleteBySessionId(ctx, session.Id); perr != nil {
+				return perr
+			}
+			log.Println("Deleted the raw data of session " + session.Id.String())
+		}
+	}
+
+	// Delete the files, archived or not
+	if policy.FileDays != nil {
+		sessions, perr := enforcer.retention.FindEndedSessions(ctx, policy.OrganizationId, now-int64(*policy.FileDays)*day)
+		if perr != nil {
+			return perr
+		}
+		for _, session := range sessions {
+			key := storage.SessionKey(session)
+			if size, archived := storage.FileSize(ctx, enforcer.storage, key); size == 0 && !archived {
+				continue
+			}
+			for _, name := range []string{key, key + storage.ArchiveExtension} {
+				if err := enforcer.storage.Delete(ctx, name); err != nil {
+					return err
+				}
+			}
+			log.Println("Deleted the file of session " + session.Id.String())
+		}
+	}
+
+	// Compress the files that are left
+	if policy.ArchiveDays != nil {
+		sessions, perr := enforcer.retention.FindEndedSessions(ctx, policy.OrganizationId, now-int64(*policy.ArchiveDays)*day)
+		if perr != nil {
+			return perr
+		}
+		for _, session := range sessions {
+			archived, err := enforcer.archive(ctx, storage.SessionKey(session))
+			if err != nil {
+				return err
+			}
+			if archived {
+				log.Println("Archived the file of session " + session.Id.String())
+			}
+		}
+	}
+	return nil
+}
+
+// Compresses a file into its archive, the file is only removed once the
+// archive is stored. Returns false if there is no file to archive.
+func (enforcer *Enforcer) archive(ctx context.Context, key string) (bool, error) {
+	file, err := enforcer.storage.Open(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	// Compress to the local disk first, the size must be known to store it
+	archiveFile, err := os.CreateTemp("", "archive-*"+storage.ArchiveExtension)
+	if err != nil {
+		return false, err
+	}
+	tempFileName := archiveFile.Name()
+	writer := gzip.NewWriter(archiveFile)
+	_, err = io.Copy(writer, file)
+	if err == nil {
+		err = writer.Close()
+	}
+	if cerr := archiveFile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = storage.PutFile(ctx, enforcer.storage, key+storage.ArchiveExtension, tempFileName)
+	}
+	if err != nil {
+		os.Remove(tempFileName)
+		return false, err
+	}
+	file.Close()
+	return true, enforcer.storage.Delete(ctx, key)
+}
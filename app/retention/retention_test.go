@@ -0,0 +1,202 @@
+package retention
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"database-ms/app/storage"
+	"database-ms/config"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+)
+
+// Returns the sessions for every query and records how many were made
+type stubRetentionService struct {
+	services.RetentionServiceInterface
+	sessions []*model.Session
+	queries  int
+}
+
+func (service *stubRetentionService) FindEndedSessions(ctx context.Context, organizationId uuid.UUID, endedBefore int64, tables ...string) ([]*model.Session, *pgconn.PgError) {
+	service.queries++
+	return service.sessions, nil
+}
+
+// Returns the stored summaries, or the computed ones if none are stored
+type stubSummaryService struct {
+	services.SummaryServiceInterface
+	stored   []*model.SensorSummary
+	computed []*model.SensorSummary
+	failing  bool
+}
+
+func (service *stubSummaryService) FindBySessionId(ctx context.Context, sessionId uuid.UUID) ([]*model.SensorSummary, *pgconn.PgError) {
+	return service.stored, nil
+}
+
+func (service *stubSummaryService) Compute(ctx context.Context, sessionId uuid.UUID) ([]*model.SensorSummary, *pgconn.PgError) {
+	if service.failing {
+		return nil, &pgconn.PgError{Message: "unavailable"}
+	}
+	return service.computed, nil
+}
+
+// Records the sessions whose data is deleted
+type stubDatumService struct {
+	services.DatumServiceInterface
+	deleted []uuid.UUID
+}
+
+func (service *stubDatumService) DeleteBySessionId(ctx context.Context, sessionId uuid.UUID) *pgconn.PgError {
+	service.deleted = append(service.deleted, sessionId)
+	return nil
+}
+
+type stubRawChunkService struct {
+	services.RawChunkServiceInterface
+	deleted []uuid.UUID
+}
+
+func (service *stubRawChunkService) DeleteBySessionId(ctx context.Context, sessionId uuid.UUID) *pgconn.PgError {
+	service.deleted = append(service.deleted, sessionId)
+	return nil
+}
+
+// Keeps the files on the local disk and records every change, Put fails
+// while failing is set
+type recordingStorage struct {
+	*storage.LocalStorage
+	changes []string
+	failing bool
+}
+
+func (store *recordingStorage) Put(ctx context.Context, key string, content io.Reader, size int64) error {
+	if store.failing {
+		return errors.New("unavailable")
+	}
+	store.changes = append(store.changes, "put "+key)
+	return store.LocalStorage.Put(ctx, key, content, size)
+}
+
+func (store *recordingStorage) Delete(ctx context.Context, key string) error {
+	store.changes = append(store.changes, "delete "+key)
+	return store.LocalStorage.Delete(ctx, key)
+}
+
+func days(value int) *int {
+	return &value
+}
+
+// Builds an enforcer for one ended session with a stored file
+func testEnforcer(t *testing.T) (*Enforcer, *model.Session) {
+	t.Helper()
+	session := &model.Session{Base: model.Base{Id: uuid.New()}, ThingId: uuid.New()}
+	store := &recordingStorage{LocalStorage: storage.NewLocalStorage(t.TempDir() + "/")}
+	content := []byte("Timestamp,speed\n1,10\n")
+	if err := store.LocalStorage.Put(context.Background(), storage.SessionKey(session), bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	enforcer := &Enforcer{
+		conf:      &config.Configuration{},
+		storage:   store,
+		retention: &stubRetentionService{sessions: []*model.Session{session}},
+		summary:   &stubSummaryService{},
+		datum:     &stubDatumService{},
+		rawChunk:  &stubRawChunkService{},
+	}
+	return enforcer, session
+}
+
+func TestEnforceKeepsDataWithoutSummary(t *testing.T) {
+	summary := []*model.SensorSummary{{SensorId: uuid.New()}}
+	tests := []struct {
+		name    string
+		summary *stubSummaryService
+		deleted bool
+		fails   bool
+	}{
+		{"stored summary", &stubSummaryService{stored: summary}, true, false},
+		{"computed summary", &stubSummaryService{computed: summary}, true, false},
+		{"no summary", &stubSummaryService{}, false, false},
+		{"summary fails", &stubSummaryService{failing: true}, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			enforcer, session := testEnforcer(t)
+			enforcer.summary = test.summary
+			err := enforcer.enforce(context.Background(), &model.RetentionPolicy{DatumDays: days(30)}, 0)
+			if (err != nil) != test.fails {
+				t.Fatalf("unexpected error %v", err)
+			}
+			deleted := enforcer.datum.(*stubDatumService).deleted
+			if test.deleted != reflect.DeepEqual(deleted, []uuid.UUID{session.Id}) {
+				t.Fatalf("expected the data to be deleted %v, deleted %v", test.deleted, deleted)
+			}
+		})
+	}
+}
+
+func TestEnforceArchivesBeforeDeleting(t *testing.T) {
+	enforcer, session := testEnforcer(t)
+	store := enforcer.storage.(*recordingStorage)
+	key := storage.SessionKey(session)
+	policy := &model.RetentionPolicy{ArchiveDays: days(7)}
+
+	// The file is kept when the archive cannot be stored
+	store.failing = true
+	if err := enforcer.enforce(context.Background(), policy, 0); err == nil {
+		t.Fatal("expected the archive to fail")
+	}
+	if _, err := store.Stat(context.Background(), key); err != nil || len(store.changes) != 0 {
+		t.Fatalf("expected the file to be kept untouched, got %v and %v", err, store.changes)
+	}
+
+	// Otherwise the archive is stored before the file is deleted
+	store.failing = false
+	if err := enforcer.enforce(context.Background(), policy, 0); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"put " + key + storage.ArchiveExtension, "delete " + key}
+	if !reflect.DeepEqual(store.changes, expected) {
+		t.Fatalf("expected %v, got %v", expected, store.changes)
+	}
+	file, err := store.Open(context.Background(), key+storage.ArchiveExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil || string(content) != "Timestamp,speed\n1,10\n" {
+		t.Fatalf("unexpected archive content %q, %v", content, err)
+	}
+}
+
+func TestEnforceEmptyPolicy(t *testing.T) {
+	enforcer, _ := testEnforcer(t)
+	if err := enforcer.enforce(context.Background(), &model.RetentionPolicy{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if queries := enforcer.retention.(*stubRetentionService).queries; queries != 0 {
+		t.Errorf("expected no sessions to be read, got %d queries", queries)
+	}
+	if deleted := enforcer.datum.(*stubDatumService).deleted; len(deleted) != 0 {
+		t.Errorf("expected no sensor data to be deleted, got %v", deleted)
+	}
+	if deleted := enforcer.rawChunk.(*stubRawChunkService).deleted; len(deleted) != 0 {
+		t.Errorf("expected no raw data to be deleted, got %v", deleted)
+	}
+	if changes := enforcer.storage.(*recordingStorage).changes; len(changes) != 0 {
+		t.Errorf("expected no files to change, got %v", changes)
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/utils"
+	"database-ms/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+type AlarmServiceInterface interface {
+	// Public
+	FindBySessionId(context.Context, uuid.UUID, *AlarmQuery) ([]*model.Alarm, *pgconn.PgError)
+
+	// Private
+	FindOpenBySessionId(context.Context, uuid.UUID) ([]*model.Alarm, *pgconn.PgError)
+	SaveMany(context.Context, []*model.Alarm) *pgconn.PgError
+	EndOpenBySessionId(context.Context, uuid.UUID, int64) *pgconn.PgError
+	DeleteBySessionId(context.Context, uuid.UUID) *pgconn.PgError
+}
+
+type AlarmService struct {
+	db     *gorm.DB
+	config *config.Configuration
+}
+
+// AlarmQuery narrows the alarms of a session, unset fields match everything
+type AlarmQuery struct {
+	SensorId *uuid.UUID
+	Level    string
+}
+
+func NewAlarmService(db *gorm.DB, c *config.Configuration) AlarmServiceInterface {
+	return &AlarmService{config: c, db: db}
+}
+
+// PUBLIC FUNCTIONS
+
+func (service *AlarmService) FindBySessionId(ctx context.Context, sessionId uuid.UUID, query *AlarmQuery) ([]*model.Alarm, *pgconn.PgError) {
+	alarms := []*model.Alarm{}
+	db := service.db.Where("session_id = ?", sessionId)
+	if query != nil && query.SensorId != nil {
+		db = db.Where("sensor_id = ?", *query.SensorId)
+	}
+	if query != nil && query.Level != "" {
+		db = db.Where("level = ?", query.Level)
+	}
+	result := db.Order("start_time asc").Find(&alarms)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return alarms, nil
+}
+
+// PRIVATE FUNCTIONS
+
+func (service *AlarmService) FindOpenBySessionId(ctx context.Context, sessionId uuid.UUID) ([]*model.Alarm, *pgconn.PgError) {
+	var alarms []*model.Alarm
+	result := service.db.Where("session_id = ? AND end_time IS NULL", sessionId).Find(&alarms)
+	if result.Error != nil {
+		return nil, &pgconn.PgError{Message: result.Error.Error()}
+	}
+	return alarms, nil
+}
+
+// Creates or updates the alarms in one transaction
+func (service *AlarmService) SaveMany(ctx context.Context, alarms []*model.Alarm) *pgconn.PgError {
+	err := service.db.Transaction(func(tx *gorm.DB) error {
+		for _, alarm := range alarms {
+			if result := tx.Save(alarm); result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return &pgconn.PgError{Message: err.Error()}
+	}
+	return nil
+}
+
+// Ends the alarms of a session that are still active
+func (service *AlarmService) EndOpenBySessionId(ctx context.Context, sessionId uuid.UUID, endTime int64) *pgconn.PgError {
+	result := service.db.Model(&model.Alarm{}).
+		Where("session_id = ? AND end_time IS NULL", sessionId).
+		Update("end_time", endTime)
+	if result.Error != nil {
+		return &pgconn.PgError{Message: result.Error.Error()}
+	}
+	return nil
+}
+
+func (service *AlarmService) DeleteBySessionId(ctx context.Context, sessionId uuid.UUID) *pgconn.PgError {
+	result := service.db.Where("session_id = ?", sessionId).Delete(&model.Alarm{})
+	if result.Error != nil {
+		return &pgconn.PgError{Message: result.Error.Error()}
+	}
+	return nil
+}
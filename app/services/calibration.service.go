@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/utils"
+	"database-ms/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+type CalibrationServiceInterface interface {
+	// Public
+	FindBySensorId(context.Context, uuid.UUID) ([]*model.SensorCalibration, *pgconn.PgError)
+
+	// Private
+	FindBySensorIds(context.Context, []uuid.UUID) ([]*model.SensorCalibration, *pgconn.PgError)
+}
+
+type CalibrationService struct {
+	db     *gorm.DB
+	config *config.Configuration
+}
+
+func NewCalibrationService(db *gorm.DB, c *config.Configuration) CalibrationServiceInterface {
+	return &CalibrationService{config: c, db: db}
+}
+
+// PUBLIC FUNCTIONS
+
+func (service *CalibrationService) FindBySensorId(ctx context.Context, sensorId uuid.UUID) ([]*model.SensorCalibration, *pgconn.PgError) {
+	calibrations := []*model.SensorCalibration{}
+	result := service.db.Where("sensor_id = ?", sensorId).Order("version asc").Find(&calibrations)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return calibrations, nil
+}
+
+// PRIVATE FUNCTIONS
+
+// Finds every calibration version of the sensors ordered by version
+func (service *CalibrationService) FindBySensorIds(ctx context.Context, sensorIds []uuid.UUID) ([]*model.SensorCalibration, *pgconn.PgError) {
+	calibrations := []*model.SensorCalibration{}
+	if len(sensorIds) == 0 {
+		return calibrations, nil
+	}
+	result := service.db.Where("sensor_id IN ?", sensorIds).Order("version asc").Find(&calibrations)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return calibrations, nil
+}
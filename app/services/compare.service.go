@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+// Ways sessions are lined up for comparison
+const (
+	AlignTime     = "time"
+	AlignDistance = "distance"
+	AlignLap      = "lap"
+)
+
+type CompareServiceInterface interface {
+	// Public
+	Compare(context.Context, []*model.Session, *CompareQuery) (*Comparison, *pgconn.PgError)
+}
+
+type CompareService struct {
+	db      *gorm.DB
+	config  *config.Configuration
+	datum   DatumServiceInterface
+	summary SummaryServiceInterface
+	lap     LapServiceInterface
+}
+
+// CompareQuery selects the sensors to compare and how the sessions are
+// lined up. Distance alignment needs the sensor that holds the distance.
+type CompareQuery struct {
+	SensorIds         []uuid.UUID
+	Align             string
+	DistanceSensorId  *uuid.UUID
+	BaselineSessionId *uuid.UUID
+	Datum             *DatumQuery
+}
+
+// Comparison holds each session's series on a shared axis, with summary
+// deltas against the baseline session. The axis is the time in milliseconds
+// since the session's start, the distance covered since the first distance
+// value, or the lap index where lap 2.5 is halfway through the second lap.
+type Comparison struct {
+	Align             string               `json:"align"`
+	SensorIds         []uuid.UUID          `json:"sensorIds"`
+	BaselineSessionId uuid.UUID            `json:"baselineSessionId"`
+	Sessions          []*SessionComparison `json:"sessions"`
+}
+
+type SessionComparison struct {
+	SessionId uuid.UUID                   `json:"sessionId"`
+	Name      string                      `json:"name"`
+	X         []float64                   `json:"x"`
+	Columns   map[uuid.UUID][]*float64    `json:"columns"`
+	Summary   []*model.SensorSummary      `json:"summary"`
+	Deltas    map[uuid.UUID]*SummaryDelta `json:"deltas"`
+}
+
+// SummaryDelta is the difference of a sensor's statistics from the baseline
+type SummaryDelta struct {
+	Mean          float64 `json:"mean"`
+	Min           float64 `json:"min"`
+	Max           float64 `json:"max"`
+	Stddev        float64 `json:"stddev"`
+	P50           float64 `json:"p50"`
+	P95           float64 `json:"p95"`
+	TimeInWarning int64   `json:"timeInWarning"`
+	TimeInDanger  int64   `json:"timeInDanger"`
+}
+
+func NewCompareService(db *gorm.DB, c *config.Configuration) CompareServiceInterface {
+	return &CompareService{
+		config:  c,
+		db:      db,
+		datum:   NewDatumService(db, c),
+		summary: NewSummaryService(db, c),
+		lap:     NewLapService(db, c),
+	}
+}
+
+// PUBLIC FUNCTIONS
+
+// Compares the sessions, the baseline is the first session unless one is set
+func (service *CompareService) Compare(ctx context.Context, sessions []*model.Session, query *CompareQuery) (*Comparison, *pgconn.PgError) {
+	comparison := &Comparison{
+		Align:     query.Align,
+		SensorIds: query.SensorIds,
+		Sessions:  []*SessionComparison{},
+	}
+	if len(sessions) == 0 {
+		return comparison, nil
+	}
+	comparison.BaselineSessionId = sessions[0].Id
+	if query.BaselineSessionId != nil {
+		comparison.BaselineSessionId = *query.BaselineSessionId
+	}
+
+	// The distance sensor is read along with the compared sensors
+	sensorIds := query.SensorIds
+	if query.Align == AlignDistance && !containsId(sensorIds, *query.DistanceSensorId) {
+		sensorIds = append(append([]uuid.UUID{}, sensorIds...), *query.DistanceSensorId)
+	}
+
+	summaries := make(map[uuid.UUID]map[uuid.UUID]*model.SensorSummary)
+	for _, session := range sessions {
+		aligned, perr := service.datum.FindAlignedBySessionId(ctx, session.Id, sensorIds, query.Datum)
+		if perr != nil {
+			return nil, perr
+		}
+		sessionComparison := &SessionComparison{SessionId: session.Id, Name: session.Name}
+		switch query.Align {
+		case AlignDistance:
+			alignOnDistance(sessionComparison, aligned, query.SensorIds, *query.DistanceSensorId)
+		case AlignLap:
+			laps, perr := service.lap.FindBySessionId(ctx, session.Id)
+			if perr != nil {
+				return nil, perr
+			}
+			alignOnLaps(sessionComparison, aligned, query.SensorIds, laps)
+		default:
+			alignOnTime(sessionComparison, aligned, query.SensorIds, session)
+		}
+
+		// Attempt to read the summary, ended sessions without one are summarized now
+		summary, perr := service.summary.FindBySessionId(ctx, session.Id)
+		if perr == nil && len(summary) == 0 && session.EndTime != nil {
+			summary, perr = service.summary.Compute(ctx, session.Id)
+		}
+		if perr != nil {
+			return nil, perr
+		}
+		sessionComparison.Summary = []*model.SensorSummary{}
+		summaries[session.Id] = make(map[uuid.UUID]*model.SensorSummary)
+		for _, sensorSummary := range summary {
+			if containsId(query.SensorIds, sensorSummary.SensorId) {
+				sessionComparison.Summary = append(sessionComparison.Summary, sensorSummary)
+				summaries[session.Id][sensorSummary.SensorId] = sensorSummary
+			}
+		}
+		comparison.Sessions = append(comparison.Sessions, sessionComparison)
+	}
+
+	// Compare each session's statistics with the baseline's
+	baseline := summaries[comparison.BaselineSessionId]
+	for _, sessionComparison := range comparison.Sessions {
+		sessionComparison.Deltas = make(map[uuid.UUID]*SummaryDelta)
+		for sensorId, summary := range summaries[sessionComparison.SessionId] {
+			if base, ok := baseline[sensorId]; ok {
+				sessionComparison.Deltas[sensorId] = &SummaryDelta{
+					Mean:          summary.Mean - base.Mean,
+					Min:           summary.Min - base.Min,
+					Max:           summary.Max - base.Max,
+					Stddev:        summary.Stddev - base.Stddev,
+					P50:           summary.P50 - base.P50,
+					P95:           summary.P95 - base.P95,
+					TimeInWarning: summary.TimeInWarning - base.TimeInWarning,
+					TimeInDanger:  summary.TimeInDanger - base.TimeInDanger,
+				}
+			}
+		}
+	}
+	return comparison, nil
+}
+
+// PRIVATE FUNCTIONS
+
+// Lines the data up on the time since the session's start. Timestamps before
+// the start are relative to the thing's clock so the first sample is used as
+// the start instead.
+func alignOnTime(sessionComparison *SessionComparison, aligned *AlignedData, sensorIds []uuid.UUID, session *model.Session) {
+	start := session.StartTime
+	if len(aligned.Timestamps) > 0 && aligned.Timestamps[0] < start {
+		start = aligned.Timestamps[0]
+	}
+	sessionComparison.X = make([]float64, len(aligned.Timestamps))
+	for i, timestamp := range aligned.Timestamps {
+		sessionComparison.X[i] = float64(timestamp - start)
+	}
+	sessionComparison.Columns = make(map[uuid.UUID][]*float64)
+	for _, sensorId := range sensorIds {
+		sessionComparison.Columns[sensorId] = aligned.Columns[sensorId]
+	}
+}
+
+// Lines the data up on the distance covered since the first distance value.
+// The distance is held between its samples and rows before it are dropped.
+func alignOnDistance(sessionComparison *SessionComparison, aligned *AlignedData, sensorIds []uuid.UUID, distanceSensorId uuid.UUID) {
+	sessionComparison.X = []float64{}
+	sessionComparison.Columns = make(map[uuid.UUID][]*float64)
+	for _, sensorId := range sensorIds {
+		sessionComparison.Columns[sensorId] = []*float64{}
+	}
+
+	var start, distance *float64
+	for i := range aligned.Timestamps {
+		if value := aligned.Columns[distanceSensorId][i]; value != nil {
+			distance = value
+			if start == nil {
+				start = value
+			}
+		}
+		if distance == nil {
+			continue
+		}
+		sessionComparison.X = append(sessionComparison.X, *distance-*start)
+		for _, sensorId := range sensorIds {
+			sessionComparison.Columns[sensorId] = append(sessionComparison.Columns[sensorId], aligned.Columns[sensorId][i])
+		}
+	}
+}
+
+// Lines the data up on the lap index, the lap's number plus the share of the
+// lap time elapsed. Rows outside of the laps are dropped.
+func alignOnLaps(sessionComparison *SessionComparison, aligned *AlignedData, sensorIds []uuid.UUID, laps []*model.Lap) {
+	sessionComparison.X = []float64{}
+	sessionComparison.Columns = make(map[uuid.UUID][]*float64)
+	for _, sensorId := range sensorIds {
+		sessionComparison.Columns[sensorId] = []*float64{}
+	}
+
+	lap := 0
+	for i, timestamp := range aligned.Timestamps {
+		for lap < len(laps) && timestamp >= laps[lap].EndTime {
+			lap++
+		}
+		if lap == len(laps) {
+			break
+		}
+		if timestamp < laps[lap].StartTime || laps[lap].LapTime <= 0 {
+			continue
+		}
+		x := float64(laps[lap].Number) + float64(timestamp-laps[lap].StartTime)/float64(laps[lap].LapTime)
+		sessionComparison.X = append(sessionComparison.X, x)
+		for _, sensorId := range sensorIds {
+			sessionComparison.Columns[sensorId] = append(sessionComparison.Columns[sensorId], aligned.Columns[sensorId][i])
+		}
+	}
+}
+
+func containsId(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, value := range ids {
+		if value == id {
+			return true
+		}
+	}
+	return false
+}
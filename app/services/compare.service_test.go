@@ -0,0 +1,64 @@
+package services
+
+import (
+	"database-ms/app/model"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func TestAlignOnTime(t *testing.T) {
+	sensorId := uuid.New()
+	tests := []struct {
+		name       string
+		startTime  int64
+		timestamps []int64
+		expected   []float64
+	}{
+		{"from the session's start", 1000, []int64{1500, 2000}, []float64{500, 1000}},
+		{"thing clock", 1000, []int64{10, 20}, []float64{0, 10}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			aligned := &AlignedData{
+				Timestamps: test.timestamps,
+				Columns:    map[uuid.UUID][]*float64{sensorId: {float(1), float(2)}},
+			}
+			comparison := &SessionComparison{}
+			alignOnTime(comparison, aligned, []uuid.UUID{sensorId}, &model.Session{StartTime: test.startTime})
+			if !reflect.DeepEqual(comparison.X, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, comparison.X)
+			}
+		})
+	}
+}
+
+func TestAlignOnLaps(t *testing.T) {
+	sensorId := uuid.New()
+	aligned := &AlignedData{
+		Timestamps: []int64{50, 100, 150, 200, 250, 300, 350},
+		Columns:    map[uuid.UUID][]*float64{sensorId: {float(0), float(1), float(2), float(3), float(4), float(5), float(6)}},
+	}
+	laps := []*model.Lap{
+		{Number: 1, StartTime: 100, EndTime: 200, LapTime: 100},
+		{Number: 2, StartTime: 200, EndTime: 300, LapTime: 100},
+	}
+	comparison := &SessionComparison{}
+	alignOnLaps(comparison, aligned, []uuid.UUID{sensorId}, laps)
+
+	if expected := []float64{1, 1.5, 2, 2.5}; !reflect.DeepEqual(comparison.X, expected) {
+		t.Fatalf("expected %v, got %v", expected, comparison.X)
+	}
+	var values []float64
+	for _, value := range comparison.Columns[sensorId] {
+		values = append(values, *value)
+	}
+	if expected := []float64{1, 2, 3, 4}; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected %v, got %v", expected, values)
+	}
+}
@@ -5,16 +5,48 @@ import (
 	"database-ms/app/model"
 	"database-ms/app/utils"
 	"database-ms/config"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
 	"gorm.io/gorm"
 )
 
+// Aggregation modes used when downsampling sensor data
+const (
+	AggregationAvg  = "avg"
+	AggregationMin  = "min"
+	AggregationMax  = "max"
+	AggregationLttb = "lttb"
+)
+
+// Ways of filling the timestamps where a sensor has no sample when several
+// sensors are aligned
+const (
+	FillNone   = "none"
+	FillHold   = "hold"
+	FillLinear = "linear"
+)
+
 type DatumServiceInterface interface {
 	// Public
-	FindBySessionIdAndSensorId(context.Context, uuid.UUID, uuid.UUID) ([]*SensorData, *pgconn.PgError)
-	CreateMany(context.Context, []*model.Datum) *pgconn.PgError
+	FindBySessionIdAndSensorId(context.Context, uuid.UUID, uuid.UUID, *DatumQuery) ([]*SensorData, *pgconn.PgError)
+	FindAlignedBySessionId(context.Context, uuid.UUID, []uuid.UUID, *DatumQuery) (*AlignedData, *pgconn.PgError)
+	CreateMany(context.Context, *gorm.DB, []*model.Datum) *pgconn.PgError
+	ReplaceBySessionId(context.Context, *gorm.DB, uuid.UUID, func(insert func([]*model.Datum) error) error) *pgconn.PgError
+	DeleteBySessionId(context.Context, uuid.UUID) *pgconn.PgError
+	FindExtentBySessionId(context.Context, uuid.UUID) (*DatumExtent, *pgconn.PgError)
+	CompactBySessionId(context.Context, uuid.UUID) *pgconn.PgError
+
+	// Private
+	CreateManyFlushed(context.Context, uuid.UUID, []*model.Datum, int64) *pgconn.PgError
 }
 
 type DatumService struct {
@@ -27,24 +59,581 @@ type SensorData struct {
 	Y float64 `json:"y"`
 }
 
+// AlignedData holds several sensors on a shared timestamp axis. Each column
+// has one entry per timestamp, nil where the sensor has no value.
+type AlignedData struct {
+	Timestamps []int64                  `json:"timestamps"`
+	SensorIds  []uuid.UUID              `json:"sensorIds"`
+	Columns    map[uuid.UUID][]*float64 `json:"columns"`
+}
+
+// DatumExtent is the first and last timestamp of a session's data, both are
+// nil when the session has no data
+type DatumExtent struct {
+	First *int64
+	Last  *int64
+}
+
+// DatumQuery bounds and downsamples a sensor data query. From and To are
+// inclusive timestamps, a MaxPoints of zero disables downsampling. Fill only
+// applies to aligned data, nothing is filled when it is empty.
+type DatumQuery struct {
+	From        *int64
+	To          *int64
+	MaxPoints   int
+	Aggregation string
+	Fill        string
+}
+
 func NewDatumService(db *gorm.DB, c *config.Configuration) DatumServiceInterface {
 	return &DatumService{config: c, db: db}
 }
 
-func (service *DatumService) FindBySessionIdAndSensorId(ctx context.Context, sessionId uuid.UUID, sensorId uuid.UUID) ([]*SensorData, *pgconn.PgError) {
-	var data []*model.Datum
-	result := service.db.Order("timestamp asc").Find(&data, "session_id = ? AND sensor_id = ?", sessionId, sensorId)
+// PUBLIC FUNCTIONS
+
+func (service *DatumService) FindBySessionIdAndSensorId(ctx context.Context, sessionId uuid.UUID, sensorId uuid.UUID, query *DatumQuery) ([]*SensorData, *pgconn.PgError) {
+	if query == nil {
+		query = &DatumQuery{}
+	}
+
+	// Count the data in the window to decide whether downsampling is needed
+	var count int64
+	result := service.windowQuery(sessionId, []uuid.UUID{sensorId}, query).Count(&count)
 	if result.Error != nil {
 		return nil, utils.GetPostgresError(result.Error)
 	}
+
+	// Bucket the data in the database for the simple aggregations
+	if query.MaxPoints > 0 && count > int64(query.MaxPoints) && query.Aggregation != AggregationLttb {
+		return service.findBucketed(sessionId, sensorId, query)
+	}
+
+	// Otherwise read the raw data in the window
 	cleanData := []*SensorData{}
-	for _, datum := range data {
-		cleanData = append(cleanData, &SensorData{X: datum.Timestamp, Y: datum.Value})
+	result = service.windowQuery(sessionId, []uuid.UUID{sensorId}, query).
+		Select("timestamp AS x, value AS y").
+		Order("timestamp asc").
+		Scan(&cleanData)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+
+	// Downsample the raw data with LTTB if requested
+	if query.MaxPoints > 0 && len(cleanData) > query.MaxPoints {
+		cleanData = downsampleLttb(cleanData, query.MaxPoints)
 	}
 	return cleanData, nil
 }
 
-func (service *DatumService) CreateMany(ctx context.Context, datumArray []*model.Datum) *pgconn.PgError {
-	result := service.db.CreateInBatches(datumArray, 100)
-	return utils.GetPostgresError(result.Error)
+// FindAlignedBySessionId reads several sensors on a shared timestamp axis. When
+// the sensors have more distinct timestamps than MaxPoints in the window, every
+// sensor is bucketed over the same extent so the axis has at most MaxPoints
+// timestamps. LTTB picks different points for each sensor, aligned data is
+// averaged instead.
+func (service *DatumService) FindAlignedBySessionId(ctx context.Context, sessionId uuid.UUID, sensorIds []uuid.UUID, query *DatumQuery) (*AlignedData, *pgconn.PgError) {
+	if query == nil {
+		query = &DatumQuery{}
+	}
+	aligned := &AlignedData{
+		Timestamps: []int64{},
+		SensorIds:  sensorIds,
+		Columns:    make(map[uuid.UUID][]*float64),
+	}
+
+	// Find the extent shared by all the sensors so they are bucketed alike
+	var extent struct {
+		First *int64
+		Last  *int64
+		Count int64
+	}
+	result := service.windowQuery(sessionId, sensorIds, query).
+		Select("MIN(timestamp) AS first, MAX(timestamp) AS last, COUNT(DISTINCT timestamp) AS count").
+		Scan(&extent)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	if extent.First == nil || extent.Last == nil {
+		for _, sensorId := range sensorIds {
+			aligned.Columns[sensorId] = []*float64{}
+		}
+		return aligned, nil
+	}
+	sharedQuery := &DatumQuery{From: extent.First, To: extent.Last}
+	bucketed := query.MaxPoints > 0 && extent.Count > int64(query.MaxPoints)
+	if bucketed {
+		sharedQuery.MaxPoints = query.MaxPoints
+		sharedQuery.Aggregation = query.Aggregation
+		if sharedQuery.Aggregation == AggregationLttb || sharedQuery.Aggregation == "" {
+			sharedQuery.Aggregation = AggregationAvg
+		}
+	}
+
+	// Read each sensor's series and collect the union of the timestamps
+	series := make(map[uuid.UUID]map[int64]float64)
+	seen := make(map[int64]bool)
+	for _, sensorId := range sensorIds {
+		var data []*SensorData
+		var perr *pgconn.PgError
+		if bucketed {
+			data, perr = service.findBucketed(sessionId, sensorId, sharedQuery)
+		} else {
+			data, perr = service.FindBySessionIdAndSensorId(ctx, sessionId, sensorId, sharedQuery)
+		}
+		if perr != nil {
+			return nil, perr
+		}
+		values := make(map[int64]float64, len(data))
+		for _, datum := range data {
+			values[datum.X] = datum.Y
+			if !seen[datum.X] {
+				seen[datum.X] = true
+				aligned.Timestamps = append(aligned.Timestamps, datum.X)
+			}
+		}
+		series[sensorId] = values
+	}
+	sort.Slice(aligned.Timestamps, func(i, j int) bool {
+		return aligned.Timestamps[i] < aligned.Timestamps[j]
+	})
+
+	// Build a column per sensor on the shared axis
+	for _, sensorId := range sensorIds {
+		column := make([]*float64, len(aligned.Timestamps))
+		for i, timestamp := range aligned.Timestamps {
+			if value, ok := series[sensorId][timestamp]; ok {
+				column[i] = &value
+			}
+		}
+		fillColumn(column, aligned.Timestamps, query.Fill)
+		aligned.Columns[sensorId] = column
+	}
+	return aligned, nil
+}
+
+// CreateMany packs the data into chunks per sensor and copies them in one
+// transaction, small chunks are merged when the session is compacted. The
+// chunks are inserted through tx when it is not nil, COPY needs a connection
+// of its own.
+func (service *DatumService) CreateMany(ctx context.Context, tx *gorm.DB, datumArray []*model.Datum) *pgconn.PgError {
+	chunks := packDatum(datumArray)
+	if len(chunks) == 0 {
+		return nil
+	}
+	if tx != nil {
+		if result := tx.CreateInBatches(chunks, 100); result.Error != nil {
+			return toPostgresError(result.Error)
+		}
+		return nil
+	}
+
+	err := service.copyTransaction(ctx, func(tx pgx.Tx) error {
+		return service.copyChunks(ctx, tx, chunks, nil)
+	})
+	if err != nil {
+		return toPostgresError(err)
+	}
+	return nil
+}
+
+// CreateManyFlushed copies the data like CreateMany and records in the same
+// transaction how many entries of the thing's redis list were written to the
+// session, see model.Session.FlushedEntries
+func (service *DatumService) CreateManyFlushed(ctx context.Context, sessionId uuid.UUID, datumArray []*model.Datum, flushedEntries int64) *pgconn.PgError {
+	chunks := packDatum(datumArray)
+	err := service.copyTransaction(ctx, func(tx pgx.Tx) error {
+		if err := service.copyChunks(ctx, tx, chunks, nil); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "UPDATE "+model.TableNameSession+" SET flushed_entries = $1 WHERE id = $2", flushedEntries, sessionId.String())
+		return err
+	})
+	if err != nil {
+		return toPostgresError(err)
+	}
+	return nil
+}
+
+// ReplaceBySessionId removes a session's data and inserts the batches passed
+// to insert by fill, all in one transaction. The data is replaced through tx
+// when it is not nil. Nothing is changed if fill or any batch fails.
+func (service *DatumService) ReplaceBySessionId(
+	ctx context.Context,
+	tx *gorm.DB,
+	sessionId uuid.UUID,
+	fill func(insert func([]*model.Datum) error) error,
+) *pgconn.PgError {
+	if tx != nil {
+		if perr := NewDatumService(tx, service.config).DeleteBySessionId(ctx, sessionId); perr != nil {
+			return perr
+		}
+		err := fill(func(datumArray []*model.Datum) error {
+			if perr := service.CreateMany(ctx, tx, datumArray); perr != nil {
+				return perr
+			}
+			return nil
+		})
+		if err != nil {
+			return toPostgresError(err)
+		}
+		return nil
+	}
+
+	total := 0
+	err := service.copyTransaction(ctx, func(tx pgx.Tx) error {
+		for _, table := range []string{model.TableNameDatumChunk, model.TableNameDatum} {
+			if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE session_id = $1", sessionId.String()); err != nil {
+				return err
+			}
+		}
+		return fill(func(datumArray []*model.Datum) error {
+			return service.copyChunks(ctx, tx, packDatum(datumArray), func(copied int) {
+				total += copied
+				log.Printf("Copied %d samples into session %s", total, sessionId)
+			})
+		})
+	})
+	if err != nil {
+		return toPostgresError(err)
+	}
+	return nil
+}
+
+func (service *DatumService) DeleteBySessionId(ctx context.Context, sessionId uuid.UUID) *pgconn.PgError {
+	err := service.db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("session_id = ?", sessionId).Delete(&model.DatumChunk{}); result.Error != nil {
+			return result.Error
+		}
+		return tx.Where("session_id = ?", sessionId).Delete(&model.Datum{}).Error
+	})
+	if err != nil {
+		return toPostgresError(err)
+	}
+	return nil
+}
+
+// FindExtentBySessionId reads the first and last timestamp of a session's
+// data from the chunks' bounds so no chunk is unpacked
+func (service *DatumService) FindExtentBySessionId(ctx context.Context, sessionId uuid.UUID) (*DatumExtent, *pgconn.PgError) {
+	var extent DatumExtent
+	result := service.db.Raw(extentQuery, map[string]interface{}{"session": sessionId}).Scan(&extent)
+	if result.Error != nil {
+		return nil, toPostgresError(result.Error)
+	}
+	return &extent, nil
+}
+
+// CompactBySessionId repacks a session's data into full chunks per sensor,
+// merging the small chunks written during ingestion and the rows of the
+// datum table. Sessions that are already compact are left as they are.
+func (service *DatumService) CompactBySessionId(ctx context.Context, sessionId uuid.UUID) *pgconn.PgError {
+	params := map[string]interface{}{"session": sessionId, "size": model.DatumChunkSize}
+
+	// Guard against rewriting sessions that are already compact
+	var compact bool
+	if result := service.db.Raw(compactQuery, params).Scan(&compact); result.Error != nil {
+		return toPostgresError(result.Error)
+	}
+	if compact {
+		return nil
+	}
+
+	if result := service.db.Exec(packQuery, params); result.Error != nil {
+		return toPostgresError(result.Error)
+	}
+	return nil
+}
+
+// PRIVATE FUNCTIONS
+
+// Groups the data by session and sensor and packs each sensor's samples in
+// timestamp order
+func packDatum(datumArray []*model.Datum) []*model.DatumChunk {
+	type series struct {
+		sessionId uuid.UUID
+		sensorId  uuid.UUID
+		data      []*model.Datum
+	}
+	var order []*series
+	bySensor := make(map[[2]uuid.UUID]*series)
+	for _, datum := range datumArray {
+		key := [2]uuid.UUID{datum.SessionId, datum.SensorId}
+		current, ok := bySensor[key]
+		if !ok {
+			current = &series{sessionId: datum.SessionId, sensorId: datum.SensorId}
+			bySensor[key] = current
+			order = append(order, current)
+		}
+		current.data = append(current.data, datum)
+	}
+
+	var chunks []*model.DatumChunk
+	for _, current := range order {
+		sort.SliceStable(current.data, func(i, j int) bool {
+			return current.data[i].Timestamp < current.data[j].Timestamp
+		})
+		timestamps := make([]int64, len(current.data))
+		values := make([]float64, len(current.data))
+		for i, datum := range current.data {
+			timestamps[i] = datum.Timestamp
+			values[i] = datum.Value
+		}
+		chunks = append(chunks, model.NewDatumChunks(current.sessionId, current.sensorId, timestamps, values)...)
+	}
+	return chunks
+}
+
+// Runs write in a transaction on a pgx connection of the pool, it is rolled
+// back if write fails
+func (service *DatumService) copyTransaction(ctx context.Context, write func(pgx.Tx) error) error {
+	sqlDB, err := service.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("the database connection does not support COPY")
+		}
+		tx, err := stdlibConn.Conn().Begin(ctx)
+		if err != nil {
+			return err
+		}
+		if err = write(tx); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// Copies the chunks in batches of about CopyBatchSize samples, progress is
+// called with the number of samples of every batch copied
+func (service *DatumService) copyChunks(ctx context.Context, tx pgx.Tx, chunks []*model.DatumChunk, progress func(int)) error {
+	batchSize := service.config.CopyBatchSize
+	if batchSize < model.DatumChunkSize {
+		batchSize = model.DatumChunkSize
+	}
+	columns := []string{"session_id", "sensor_id", "first_timestamp", "last_timestamp", "count", "sample_timestamps", "sample_values"}
+
+	for start := 0; start < len(chunks); {
+		// Fill the batch up to the batch size
+		end, samples := start, 0
+		for end < len(chunks) && (end == start || samples+chunks[end].Count <= batchSize) {
+			samples += chunks[end].Count
+			end++
+		}
+
+		batch := chunks[start:end]
+		_, err := tx.CopyFrom(ctx, pgx.Identifier{model.TableNameDatumChunk}, columns, pgx.CopyFromSlice(len(batch), func(i int) ([]interface{}, error) {
+			chunk := batch[i]
+			return []interface{}{
+				pgtype.UUID{Bytes: chunk.SessionId, Status: pgtype.Present},
+				pgtype.UUID{Bytes: chunk.SensorId, Status: pgtype.Present},
+				chunk.FirstTimestamp,
+				chunk.LastTimestamp,
+				int64(chunk.Count),
+				&chunk.SampleTimestamps,
+				&chunk.SampleValues,
+			}, nil
+		}))
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(samples)
+		}
+		start = end
+	}
+	return nil
+}
+
+// Errors from pgx are already postgres errors, anything else is wrapped
+func toPostgresError(err error) *pgconn.PgError {
+	if perr := utils.GetPostgresError(err); perr != nil {
+		return perr
+	}
+	return &pgconn.PgError{Message: err.Error()}
+}
+
+// Only the chunks overlapping the window are unpacked
+func (service *DatumService) windowQuery(sessionId uuid.UUID, sensorIds []uuid.UUID, query *DatumQuery) *gorm.DB {
+	tx := service.db.Table(model.TableNameDatumSample).Where("session_id = ? AND sensor_id IN ?", sessionId, sensorIds)
+	if query.From != nil {
+		tx = tx.Where("chunk_last >= ? AND timestamp >= ?", *query.From, *query.From)
+	}
+	if query.To != nil {
+		tx = tx.Where("chunk_first <= ? AND timestamp <= ?", *query.To, *query.To)
+	}
+	return tx
+}
+
+func (service *DatumService) findBucketed(sessionId uuid.UUID, sensorId uuid.UUID, query *DatumQuery) ([]*SensorData, *pgconn.PgError) {
+	// Find the extent of the window so it can be split into even buckets
+	var extent struct {
+		First int64
+		Last  int64
+	}
+	result := service.windowQuery(sessionId, []uuid.UUID{sensorId}, query).
+		Select("MIN(timestamp) AS first, MAX(timestamp) AS last").
+		Scan(&extent)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	if query.From != nil {
+		extent.First = *query.From
+	}
+	if query.To != nil {
+		extent.Last = *query.To
+	}
+	width := int64(math.Ceil(float64(extent.Last-extent.First+1) / float64(query.MaxPoints)))
+	if width < 1 {
+		width = 1
+	}
+
+	// Aggregate each bucket, placing it at the start of the bucket so that
+	// sensors queried over the same window share the same timestamps
+	aggregate := "AVG(value)"
+	switch query.Aggregation {
+	case AggregationMin:
+		aggregate = "MIN(value)"
+	case AggregationMax:
+		aggregate = "MAX(value)"
+	}
+	bucket := "(timestamp - " + strconv.FormatInt(extent.First, 10) + ") / " + strconv.FormatInt(width, 10)
+	data := []*SensorData{}
+	result = service.windowQuery(sessionId, []uuid.UUID{sensorId}, query).
+		Select(strconv.FormatInt(extent.First, 10) + " + " + bucket + " * " + strconv.FormatInt(width, 10) + " AS x, " + aggregate + " AS y").
+		Group(bucket).
+		Order("x asc").
+		Scan(&data)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return data, nil
+}
+
+const extentQuery = `
+SELECT MIN(first) AS first, MAX(last) AS last FROM (
+	SELECT MIN(first_timestamp) AS first, MAX(last_timestamp) AS last
+	FROM datum_chunk WHERE session_id = @session
+	UNION ALL
+	SELECT MIN(timestamp), MAX(timestamp)
+	FROM datum WHERE session_id = @session
+) extent`
+
+// A session is compact when it has no rows in the datum table and every
+// sensor has at most one chunk that is not full
+const compactQuery = `
+SELECT NOT EXISTS (SELECT 1 FROM datum WHERE session_id = @session)
+	AND NOT EXISTS (
+		SELECT 1 FROM datum_chunk
+		WHERE session_id = @session AND count < @size
+		GROUP BY sensor_id
+		HAVING COUNT(*) > 1
+	)`
+
+// Moves all of a session's samples into new chunks in a single statement
+const packQuery = `
+WITH packed AS (
+	DELETE FROM datum_chunk WHERE session_id = @session
+	RETURNING sensor_id, sample_timestamps, sample_values
+), legacy AS (
+	DELETE FROM datum WHERE session_id = @session
+	RETURNING sensor_id, timestamp, value
+), samples AS (
+	SELECT packed.sensor_id, sample.timestamp, sample.value
+	FROM packed, UNNEST(packed.sample_timestamps, packed.sample_values) AS sample(timestamp, value)
+	UNION ALL
+	SELECT sensor_id, timestamp, value FROM legacy
+), ranked AS (
+	SELECT sensor_id, timestamp, value,
+		(ROW_NUMBER() OVER (PARTITION BY sensor_id ORDER BY timestamp) - 1) / @size AS chunk
+	FROM samples
+)
+INSERT INTO datum_chunk (session_id, sensor_id, first_timestamp, last_timestamp, count, sample_timestamps, sample_values)
+SELECT CAST(@session AS uuid), sensor_id, MIN(timestamp), MAX(timestamp), COUNT(*),
+	ARRAY_AGG(timestamp ORDER BY timestamp), ARRAY_AGG(value ORDER BY timestamp)
+FROM ranked
+GROUP BY sensor_id, chunk`
+
+// Fills the gaps between a column's samples. Held values last until the end
+// of the column, nothing is filled before the first sample.
+func fillColumn(column []*float64, timestamps []int64, fill string) {
+	if fill != FillHold && fill != FillLinear {
+		return
+	}
+	previous := -1
+	for i, value := range column {
+		if value == nil {
+			continue
+		}
+		if previous >= 0 {
+			for j := previous + 1; j < i; j++ {
+				filled := *column[previous]
+				if fill == FillLinear {
+					ratio := float64(timestamps[j]-timestamps[previous]) / float64(timestamps[i]-timestamps[previous])
+					filled += (*value - filled) * ratio
+				}
+				column[j] = &filled
+			}
+		}
+		previous = i
+	}
+	if fill == FillHold && previous >= 0 {
+		for j := previous + 1; j < len(column); j++ {
+			column[j] = column[previous]
+		}
+	}
+}
+
+// Largest-Triangle-Three-Buckets downsampling, keeps the first and last points
+// and picks the most visually significant point from each bucket in between.
+func downsampleLttb(data []*SensorData, threshold int) []*SensorData {
+	if threshold < 3 {
+		threshold = 3
+	}
+	if threshold >= len(data) {
+		return data
+	}
+
+	sampled := make([]*SensorData, 0, threshold)
+	bucketSize := float64(len(data)-2) / float64(threshold-2)
+	selected := 0
+	sampled = append(sampled, data[0])
+	for i := 0; i < threshold-2; i++ {
+		// Average the next bucket to use as the third triangle point
+		nextStart := int(math.Floor(float64(i+1)*bucketSize)) + 1
+		nextEnd := int(math.Floor(float64(i+2)*bucketSize)) + 1
+		if nextEnd > len(data) {
+			nextEnd = len(data)
+		}
+		avgX, avgY := 0.0, 0.0
+		for _, point := range data[nextStart:nextEnd] {
+			avgX += float64(point.X)
+			avgY += point.Y
+		}
+		avgX /= float64(nextEnd - nextStart)
+		avgY /= float64(nextEnd - nextStart)
+
+		// Pick the point in the current bucket with the largest triangle
+		start := int(math.Floor(float64(i)*bucketSize)) + 1
+		end := nextStart
+		pointX, pointY := float64(data[selected].X), data[selected].Y
+		maxArea := -1.0
+		for j := start; j < end; j++ {
+			area := math.Abs((pointX-avgX)*(data[j].Y-pointY) - (pointX-float64(data[j].X))*(avgY-pointY))
+			if area > maxArea {
+				maxArea = area
+				selected = j
+			}
+		}
+		sampled = append(sampled, data[selected])
+	}
+	return append(sampled, data[len(data)-1])
 }
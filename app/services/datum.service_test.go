@@ -0,0 +1,43 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func series(values ...float64) []*SensorData {
+	data := make([]*SensorData, len(values))
+	for i, value := range values {
+		data[i] = &SensorData{X: int64(i), Y: value}
+	}
+	return data
+}
+
+func TestDownsampleLttb(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []*SensorData
+		maxPoints int
+		expected  []int64
+	}{
+		{"no data", series(), 3, []int64{}},
+		{"as many points as the data", series(1, 2, 3, 4), 4, []int64{0, 1, 2, 3}},
+		{"more points than the data", series(1, 2, 3), 10, []int64{0, 1, 2}},
+		{"fewer than three points", series(0, 0, 5, 0, 0), 1, []int64{0, 2, 4}},
+		{"three points keep the peak", series(0, 1, 0, 9, 0, 2), 3, []int64{0, 3, 5}},
+		{"three points keep the trough", series(5, 5, -4, 5, 5), 3, []int64{0, 2, 4}},
+		{"one point per bucket", series(0, 0, 0, 0, 10, 0, 0, 0, 0, 0), 5, []int64{0, 2, 4, 6, 9}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sampled := downsampleLttb(test.data, test.maxPoints)
+			timestamps := make([]int64, len(sampled))
+			for i, point := range sampled {
+				timestamps[i] = point.X
+			}
+			if !reflect.DeepEqual(timestamps, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, timestamps)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/config"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+type DeadLetterServiceInterface interface {
+	// Private
+	Create(context.Context, *model.DeadLetter) *pgconn.PgError
+}
+
+type DeadLetterService struct {
+	db     *gorm.DB
+	config *config.Configuration
+}
+
+func NewDeadLetterService(db *gorm.DB, c *config.Configuration) DeadLetterServiceInterface {
+	return &DeadLetterService{config: c, db: db}
+}
+
+// PRIVATE FUNCTIONS
+
+func (service *DeadLetterService) Create(ctx context.Context, deadLetter *model.DeadLetter) *pgconn.PgError {
+	result := service.db.Create(&deadLetter)
+	if result.Error != nil {
+		return &pgconn.PgError{Message: result.Error.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/utils"
+	"database-ms/config"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+// Crossings are ignored when they come sooner than this after the previous
+// one, unless the detection asks for another minimum
+const DefaultMinLapTime = 10000
+
+type LapServiceInterface interface {
+	// Public
+	FindBySessionId(context.Context, uuid.UUID) ([]*model.Lap, *pgconn.PgError)
+	FindById(context.Context, uuid.UUID) (*model.Lap, *pgconn.PgError)
+	FindFastestBySessionIds(context.Context, []uuid.UUID) (*model.Lap, *pgconn.PgError)
+	Detect(context.Context, uuid.UUID, *LapDetection) ([]*model.Lap, error)
+	ReplaceBySessionId(context.Context, uuid.UUID, []*model.Lap) *pgconn.PgError
+}
+
+type LapService struct {
+	db     *gorm.DB
+	config *config.Configuration
+	datum  DatumServiceInterface
+}
+
+// LapDetection describes where the start/finish line crossings of a session
+// come from. A beacon sensor crosses the line when its value rises, a GPS
+// position when it passes between the two points of the line, and manual
+// markers are the crossing times. Crossings closer than the minimum lap
+// time to the previous one are ignored.
+type LapDetection struct {
+	Source            string     `json:"source"`
+	BeaconSensorId    *uuid.UUID `json:"beaconSensorId,omitempty"`
+	LatitudeSensorId  *uuid.UUID `json:"latitudeSensorId,omitempty"`
+	LongitudeSensorId *uuid.UUID `json:"longitudeSensorId,omitempty"`
+	Line              *GpsLine   `json:"line,omitempty"`
+	Markers           []int64    `json:"markers,omitempty"`
+	MinLapTime        int64      `json:"minLapTime"`
+}
+
+// GpsLine is a start/finish line between two points in degrees
+type GpsLine struct {
+	Latitude1  float64 `json:"latitude1"`
+	Longitude1 float64 `json:"longitude1"`
+	Latitude2  float64 `json:"latitude2"`
+	Longitude2 float64 `json:"longitude2"`
+}
+
+func NewLapService(db *gorm.DB, c *config.Configuration) LapServiceInterface {
+	return &LapService{config: c, db: db, datum: NewDatumService(db, c)}
+}
+
+// PUBLIC FUNCTIONS
+
+func (service *LapService) FindBySessionId(ctx context.Context, sessionId uuid.UUID) ([]*model.Lap, *pgconn.PgError) {
+	laps := []*model.Lap{}
+	result := service.db.Where("session_id = ?", sessionId).Order("number asc").Find(&laps)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return laps, nil
+}
+
+func (service *LapService) FindById(ctx context.Context, lapId uuid.UUID) (*model.Lap, *pgconn.PgError) {
+	var lap *model.Lap
+	result := service.db.Where("id = ?", lapId).First(&lap)
+	if result.Error != nil {
+		return nil, &pgconn.PgError{}
+	}
+	return lap, nil
+}
+
+// Finds the quickest lap of the sessions, nil if they have no laps
+func (service *LapService) FindFastestBySessionIds(ctx context.Context, sessionIds []uuid.UUID) (*model.Lap, *pgconn.PgError) {
+	var laps []*model.Lap
+	result := service.db.Where("session_id IN ?", sessionIds).Order("lap_time asc").Limit(1).Find(&laps)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	if len(laps) == 0 {
+		return nil, nil
+	}
+	return laps[0], nil
+}
+
+// Finds the laps of a session from its crossings of the start/finish line.
+// The time before the first crossing and after the last are not laps.
+func (service *LapService) Detect(ctx context.Context, sessionId uuid.UUID, detection *LapDetection) ([]*model.Lap, error) {
+	var crossings []int64
+	switch detection.Source {
+	case model.LapSourceBeacon:
+		if detection.BeaconSensorId == nil {
+			return nil, errors.New("beaconSensorId is required for beacon laps")
+		}
+		data, perr := service.datum.FindBySessionIdAndSensorId(ctx, sessionId, *detection.BeaconSensorId, nil)
+		if perr != nil {
+			return nil, perr
+		}
+		crossings = beaconCrossings(data)
+	case model.LapSourceGps:
+		if detection.LatitudeSensorId == nil || detection.LongitudeSensorId == nil || detection.Line == nil {
+			return nil, errors.New("latitudeSensorId, longitudeSensorId and line are required for gps laps")
+		}
+		sensorIds := []uuid.UUID{*detection.LatitudeSensorId, *detection.LongitudeSensorId}
+		// Latitude and longitude may be sampled apart, each is held until the next
+		aligned, perr := service.datum.FindAlignedBySessionId(ctx, sessionId, sensorIds, &DatumQuery{Fill: FillHold})
+		if perr != nil {
+			return nil, perr
+		}
+		crossings = gpsCrossings(aligned, sensorIds[0], sensorIds[1], detection.Line)
+	case model.LapSourceManual:
+		crossings = append([]int64{}, detection.Markers...)
+		sort.Slice(crossings, func(i, j int) bool {
+			return crossings[i] < crossings[j]
+		})
+	default:
+		return nil, errors.New("source must be beacon, gps or manual")
+	}
+
+	minLapTime := detection.MinLapTime
+	if minLapTime <= 0 {
+		minLapTime = DefaultMinLapTime
+	}
+	laps := []*model.Lap{}
+	start := int64(0)
+	for i, crossing := range crossings {
+		if i > 0 && crossing-start < minLapTime {
+			continue
+		}
+		if i > 0 {
+			laps = append(laps, &model.Lap{
+				SessionId: sessionId,
+				Number:    len(laps) + 1,
+				StartTime: start,
+				EndTime:   crossing,
+				LapTime:   crossing - start,
+				Source:    detection.Source,
+			})
+		}
+		start = crossing
+	}
+	return laps, nil
+}
+
+// Replaces the laps of a session in one transaction
+func (service *LapService) ReplaceBySessionId(ctx context.Context, sessionId uuid.UUID, laps []*model.Lap) *pgconn.PgError {
+	err := service.db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("session_id = ?", sessionId).Delete(&model.Lap{}); result.Error != nil {
+			return result.Error
+		}
+		if len(laps) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(laps, 100).Error
+	})
+	if err != nil {
+		if perr := utils.GetPostgresError(err); perr != nil {
+			return perr
+		}
+		return &pgconn.PgError{Message: err.Error()}
+	}
+	return nil
+}
+
+// PRIVATE FUNCTIONS
+
+// A beacon crosses the line whenever its value rises, which covers both
+// pulses and lap counters
+func beaconCrossings(data []*SensorData) []int64 {
+	var crossings []int64
+	for i := 1; i < len(data); i++ {
+		if data[i].Y > data[i-1].Y {
+			crossings = append(crossings, data[i].X)
+		}
+	}
+	return crossings
+}
+
+// Finds where the path between consecutive positions crosses the line, the
+// crossing time is interpolated along the path. Positions are held between
+// their samples and treated as planar, which is fine over a few meters.
+func gpsCrossings(aligned *AlignedData, latitudeId uuid.UUID, longitudeId uuid.UUID, line *GpsLine) []int64 {
+	var crossings []int64
+	var latitude, longitude *float64
+	var prevTime int64
+	var prevLatitude, prevLongitude float64
+	hasPrev := false
+	for i, timestamp := range aligned.Timestamps {
+		if value := aligned.Columns[latitudeId][i]; value != nil {
+			latitude = value
+		}
+		if value := aligned.Columns[longitudeId][i]; value != nil {
+			longitude = value
+		}
+		if latitude == nil || longitude == nil {
+			continue
+		}
+		if hasPrev {
+			if fraction, ok := segmentIntersection(
+				prevLongitude, prevLatitude, *longitude, *latitude,
+				line.Longitude1, line.Latitude1, line.Longitude2, line.Latitude2,
+			); ok {
+				crossings = append(crossings, prevTime+int64(math.Round(fraction*float64(timestamp-prevTime))))
+			}
+		}
+		prevTime, prevLatitude, prevLongitude, hasPrev = timestamp, *latitude, *longitude, true
+	}
+	return crossings
+}
+
+// Checks if the segment from p1 to p2 crosses the segment from p3 to p4,
+// returns how far along the first segment the crossing is. A segment that
+// ends on the line is not counted so one crossing is not found twice.
+func segmentIntersection(x1, y1, x2, y2, x3, y3, x4, y4 float64) (float64, bool) {
+	denominator := (x2-x1)*(y4-y3) - (y2-y1)*(x4-x3)
+	if denominator == 0 {
+		return 0, false
+	}
+	t := ((x3-x1)*(y4-y3) - (y3-y1)*(x4-x3)) / denominator
+	u := ((x3-x1)*(y2-y1) - (y3-y1)*(x2-x1)) / denominator
+	if t <= 0 || t > 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return t, true
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/utils"
+	"database-ms/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+type RawChunkServiceInterface interface {
+	// Private
+	Create(context.Context, *model.RawChunk) *pgconn.PgError
+	FindBySessionId(context.Context, uuid.UUID) ([]*model.RawChunk, *pgconn.PgError)
+	CountBySessionId(context.Context, uuid.UUID) (int64, *pgconn.PgError)
+	DeleteBySessionId(context.Context, uuid.UUID) *pgconn.PgError
+}
+
+type RawChunkService struct {
+	db     *gorm.DB
+	config *config.Configuration
+}
+
+func NewRawChunkService(db *gorm.DB, c *config.Configuration) RawChunkServiceInterface {
+	return &RawChunkService{config: c, db: db}
+}
+
+// PRIVATE FUNCTIONS
+
+func (service *RawChunkService) Create(ctx context.Context, chunk *model.RawChunk) *pgconn.PgError {
+	result := service.db.Create(chunk)
+	if result.Error != nil {
+		if perr := utils.GetPostgresError(result.Error); perr != nil {
+			return perr
+		}
+		return &pgconn.PgError{Message: result.Error.Error()}
+	}
+	return nil
+}
+
+// Finds the chunks of a session in the order they were received
+func (service *RawChunkService) FindBySessionId(ctx context.Context, sessionId uuid.UUID) ([]*model.RawChunk, *pgconn.PgError) {
+	chunks := []*model.RawChunk{}
+	result := service.db.Where("session_id = ?", sessionId).Order("time asc, first_timestamp asc").Find(&chunks)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return chunks, nil
+}
+
+func (service *RawChunkService) CountBySessionId(ctx context.Context, sessionId uuid.UUID) (int64, *pgconn.PgError) {
+	var count int64
+	result := service.db.Model(&model.RawChunk{}).Where("session_id = ?", sessionId).Count(&count)
+	if result.Error != nil {
+		return 0, utils.GetPostgresError(result.Error)
+	}
+	return count, nil
+}
+
+func (service *RawChunkService) DeleteBySessionId(ctx context.Context, sessionId uuid.UUID) *pgconn.PgError {
+	result := service.db.Where("session_id = ?", sessionId).Delete(&model.RawChunk{})
+	if result.Error != nil {
+		return &pgconn.PgError{Message: result.Error.Error()}
+	}
+	return nil
+}
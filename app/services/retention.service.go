@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/utils"
+	"database-ms/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+type RetentionServiceInterface interface {
+	// Public
+	FindByOrganizationId(context.Context, uuid.UUID) (*model.RetentionPolicy, *pgconn.PgError)
+	Save(context.Context, *model.RetentionPolicy) *pgconn.PgError
+	FindUsageByOrganizationId(context.Context, uuid.UUID) ([]*ThingUsage, *pgconn.PgError)
+
+	// Private
+	FindAll(context.Context) ([]*model.RetentionPolicy, *pgconn.PgError)
+	FindEndedSessions(context.Context, uuid.UUID, int64, ...string) ([]*model.Session, *pgconn.PgError)
+}
+
+type RetentionService struct {
+	db     *gorm.DB
+	config *config.Configuration
+}
+
+// ThingUsage is the storage used by a thing's sessions in bytes. The file
+// sizes are not known to the database and are left for the caller.
+type ThingUsage struct {
+	ThingId      uuid.UUID       `json:"thingId"`
+	Name         string          `json:"name"`
+	Samples      int64           `json:"samples"`
+	DatumBytes   int64           `json:"datumBytes"`
+	RawDataBytes int64           `json:"rawDataBytes"`
+	FileBytes    int64           `json:"fileBytes"`
+	Sessions     []*SessionUsage `json:"sessions"`
+}
+
+type SessionUsage struct {
+	SessionId    uuid.UUID `json:"sessionId"`
+	Name         string    `json:"name"`
+	EndTime      *int64    `json:"endTime,omitempty"`
+	Samples      int64     `json:"samples"`
+	DatumBytes   int64     `json:"datumBytes"`
+	RawDataBytes int64     `json:"rawDataBytes"`
+	FileBytes    int64     `json:"fileBytes"`
+	Archived     bool      `json:"archived"`
+}
+
+func NewRetentionService(db *gorm.DB, c *config.Configuration) RetentionServiceInterface {
+	return &RetentionService{config: c, db: db}
+}
+
+// Storage of every session of an organization's things, measured as stored
+// by postgres which may be compressed
+const usageQuery = `
+SELECT thing.id AS thing_id, thing.name AS thing_name,
+	session.id AS session_id, session.name AS session_name, session.end_time,
+	COALESCE(chunk.samples, 0) + COALESCE(legacy.samples, 0) AS samples,
+	COALESCE(chunk.bytes, 0) + COALESCE(legacy.bytes, 0) AS datum_bytes,
+	COALESCE(raw.bytes, 0) AS raw_data_bytes
+FROM thing
+LEFT JOIN session ON session.thing_id = thing.id
+LEFT JOIN LATERAL (
+	SELECT SUM(count) AS samples, SUM(pg_column_size(datum_chunk.*)) AS bytes
+	FROM datum_chunk WHERE datum_chunk.session_id = session.id
+) chunk ON true
+LEFT JOIN LATERAL (
+	SELECT COUNT(*) AS samples, SUM(pg_column_size(datum.*)) AS bytes
+	FROM datum WHERE datum.session_id = session.id
+) legacy ON true
+LEFT JOIN LATERAL (
+	SELECT SUM(pg_column_size(raw_chunk.*)) AS bytes
+	FROM raw_chunk WHERE raw_chunk.session_id = session.id
+) raw ON true
+WHERE thing.organization_id = ?
+ORDER BY thing.name, session.start_time`
+
+// PUBLIC FUNCTIONS
+
+// Finds the organization's policy, organizations without one keep everything
+func (service *RetentionService) FindByOrganizationId(ctx context.Context, organizationId uuid.UUID) (*model.RetentionPolicy, *pgconn.PgError) {
+	policies := []*model.RetentionPolicy{}
+	result := service.db.Where("organization_id = ?", organizationId).Limit(1).Find(&policies)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	if len(policies) == 0 {
+		return &model.RetentionPolicy{OrganizationId: organizationId}, nil
+	}
+	return policies[0], nil
+}
+
+// Replaces the organization's policy
+func (service *RetentionService) Save(ctx context.Context, policy *model.RetentionPolicy) *pgconn.PgError {
+	existing, perr := service.FindByOrganizationId(ctx, policy.OrganizationId)
+	if perr != nil {
+		return perr
+	}
+	policy.Id = existing.Id
+	result := service.db.Save(policy)
+	if result.Error != nil {
+		return utils.GetPostgresError(result.Error)
+	}
+	return nil
+}
+
+func (service *RetentionService) FindUsageByOrganizationId(ctx context.Context, organizationId uuid.UUID) ([]*ThingUsage, *pgconn.PgError) {
+	var rows []struct {
+		ThingId      uuid.UUID
+		ThingName    string
+		SessionId    *uuid.UUID
+		SessionName  *string
+		EndTime      *int64
+		Samples      int64
+		DatumBytes   int64
+		RawDataBytes int64
+	}
+	result := service.db.Raw(usageQuery, organizationId).Scan(&rows)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+
+	// Group the sessions by thing, things without sessions are kept
+	things := []*ThingUsage{}
+	byId := make(map[uuid.UUID]*ThingUsage)
+	for _, row := range rows {
+		thing, ok := byId[row.ThingId]
+		if !ok {
+			thing = &ThingUsage{ThingId: row.ThingId, Name: row.ThingName, Sessions: []*SessionUsage{}}
+			byId[row.ThingId] = thing
+			things = append(things, thing)
+		}
+		if row.SessionId == nil {
+			continue
+		}
+		thing.Samples += row.Samples
+		thing.DatumBytes += row.DatumBytes
+		thing.RawDataBytes += row.RawDataBytes
+		thing.Sessions = append(thing.Sessions, &SessionUsage{
+			SessionId:    *row.SessionId,
+			Name:         *row.SessionName,
+			EndTime:      row.EndTime,
+			Samples:      row.Samples,
+			DatumBytes:   row.DatumBytes,
+			RawDataBytes: row.RawDataBytes,
+		})
+	}
+	return things, nil
+}
+
+// PRIVATE FUNCTIONS
+
+func (service *RetentionService) FindAll(ctx context.Context) ([]*model.RetentionPolicy, *pgconn.PgError) {
+	policies := []*model.RetentionPolicy{}
+	result := service.db.Find(&policies)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return policies, nil
+}
+
+// Finds the organization's sessions that ended before a time. When tables
+// are given only the sessions with rows in any of them are returned.
+func (service *RetentionService) FindEndedSessions(ctx context.Context, organizationId uuid.UUID, endedBefore int64, tables ...string) ([]*model.Session, *pgconn.PgError) {
+	tx := service.db.
+		Joins("JOIN thing ON thing.id = session.thing_id").
+		Where("thing.organization_id = ? AND session.end_time IS NOT NULL AND session.end_time < ?", organizationId, endedBefore)
+	if len(tables) > 0 {
+		exists := service.db.Where("false")
+		for _, table := range tables {
+			exists = exists.Or("EXISTS (SELECT 1 FROM " + table + " WHERE " + table + ".session_id = session.id)")
+		}
+		tx = tx.Where(exists)
+	}
+
+	sessions := []*model.Session{}
+	result := tx.Find(&sessions)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return sessions, nil
+}
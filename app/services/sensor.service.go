@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"database-ms/app/derived"
 	"database-ms/app/model"
 	"database-ms/app/utils"
 	"database-ms/config"
@@ -20,12 +21,17 @@ type SensorServiceInterface interface {
 	Create(context.Context, *model.Sensor) *pgconn.PgError
 	Update(context.Context, *model.Sensor) *pgconn.PgError
 	Delete(context.Context, uuid.UUID) *pgconn.PgError
+	Import(context.Context, uuid.UUID, []*model.Sensor, []*model.Sensor) *pgconn.PgError
 
 	// Private
 	FindById(context.Context, uuid.UUID) (*model.Sensor, *pgconn.PgError)
 	FindAvailableSmallId(uuid.UUID, context.Context) (int, error)
 }
 
+// Code of the error returned when a change leaves derived sensors that cannot
+// be computed, it is the code of a Postgres check violation
+const CodeInvalidExpression = "23514"
+
 type SensorService struct {
 	db     *gorm.DB
 	config *config.Configuration
@@ -95,6 +101,58 @@ func (service *SensorService) Delete(ctx context.Context, sensorId uuid.UUID) *p
 	return utils.GetPostgresError(result.Error)
 }
 
+// Creates and updates a thing's sensors in one transaction, the new sensors
+// are given the free small ids. Nothing is changed if the thing's derived
+// sensors could no longer be computed.
+func (service *SensorService) Import(ctx context.Context, thingId uuid.UUID, created []*model.Sensor, updated []*model.Sensor) *pgconn.PgError {
+	var invalid error
+	err := service.db.Transaction(func(tx *gorm.DB) error {
+		txService := &SensorService{db: tx, config: service.config}
+		lastUpdate := utils.CurrentTimeInMilli()
+		for _, sensor := range created {
+			smallId, err := txService.FindAvailableSmallId(thingId, ctx)
+			if err != nil {
+				return err
+			}
+			sensor.SmallId = smallId
+			sensor.ThingId = thingId
+			sensor.LastUpdate = lastUpdate
+			if result := tx.Create(sensor); result.Error != nil {
+				return result.Error
+			}
+		}
+		for _, sensor := range updated {
+			if sensor.ThingId != thingId {
+				return errors.New("sensor " + sensor.Id.String() + " does not belong to the thing")
+			}
+			sensor.LastUpdate = lastUpdate
+			if result := tx.Save(sensor); result.Error != nil {
+				return result.Error
+			}
+		}
+
+		// Guard against derived sensors that read a sensor that changed
+		sensors, perr := txService.FindByThingId(ctx, thingId)
+		if perr != nil {
+			return perr
+		}
+		if _, invalid = derived.Compile(sensors); invalid != nil {
+			return invalid
+		}
+		return nil
+	})
+	if invalid != nil {
+		return &pgconn.PgError{Code: CodeInvalidExpression, Message: invalid.Error()}
+	}
+	if err != nil {
+		if perr := utils.GetPostgresError(err); perr != nil {
+			return perr
+		}
+		return &pgconn.PgError{Message: err.Error()}
+	}
+	return nil
+}
+
 // PRIVATE FUNCTIONS
 
 func (service *SensorService) FindById(ctx context.Context, sensorId uuid.UUID) (*model.Sensor, *pgconn.PgError) {
@@ -20,6 +20,7 @@ type SessionServiceInterface interface {
 
 	// Private
 	FindById(context.Context, uuid.UUID) (*model.Session, *pgconn.PgError)
+	FindOpenGeneratedSessions(context.Context) ([]*model.Session, *pgconn.PgError)
 }
 
 type SessionService struct {
@@ -68,3 +69,12 @@ func (service *SessionService) FindById(ctx context.Context, sessionId uuid.UUID
 	}
 	return session, nil
 }
+
+func (service *SessionService) FindOpenGeneratedSessions(ctx context.Context) ([]*model.Session, *pgconn.PgError) {
+	var sessions []*model.Session
+	result := service.db.Where("generated = ? AND end_time IS NULL", true).Order("start_time asc").Find(&sessions)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/utils"
+	"database-ms/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+type SessionSensorServiceInterface interface {
+	// Public
+	FindBySessionId(context.Context, uuid.UUID) ([]*model.SessionSensor, *pgconn.PgError)
+
+	// Private
+	FindSensorsBySession(context.Context, *model.Session) ([]*model.Sensor, *pgconn.PgError)
+	ReplaceBySessionId(context.Context, uuid.UUID, []*model.Sensor) *pgconn.PgError
+}
+
+type SessionSensorService struct {
+	db     *gorm.DB
+	config *config.Configuration
+}
+
+func NewSessionSensorService(db *gorm.DB, c *config.Configuration) SessionSensorServiceInterface {
+	return &SessionSensorService{config: c, db: db}
+}
+
+// PUBLIC FUNCTIONS
+
+func (service *SessionSensorService) FindBySessionId(ctx context.Context, sessionId uuid.UUID) ([]*model.SessionSensor, *pgconn.PgError) {
+	snapshot := []*model.SessionSensor{}
+	result := service.db.Where("session_id = ?", sessionId).Order("small_id asc").Find(&snapshot)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return snapshot, nil
+}
+
+// PRIVATE FUNCTIONS
+
+// Finds the sensors of a session as they were when it started. Sessions of
+// a thing that had no sensors when they started take the current sensors.
+func (service *SessionSensorService) FindSensorsBySession(ctx context.Context, session *model.Session) ([]*model.Sensor, *pgconn.PgError) {
+	snapshot, perr := service.FindBySessionId(ctx, session.Id)
+	if perr != nil {
+		return nil, perr
+	}
+	if len(snapshot) == 0 {
+		if err := model.SnapshotSessionSensors(session, service.db); err != nil {
+			return nil, &pgconn.PgError{Message: err.Error()}
+		}
+		if snapshot, perr = service.FindBySessionId(ctx, session.Id); perr != nil {
+			return nil, perr
+		}
+	}
+	sensors := make([]*model.Sensor, len(snapshot))
+	for i, sessionSensor := range snapshot {
+		sensors[i] = sessionSensor.Sensor(session.ThingId)
+	}
+	return sensors, nil
+}
+
+// Replaces the snapshot of a session with the sensors in one transaction
+func (service *SessionSensorService) ReplaceBySessionId(ctx context.Context, sessionId uuid.UUID, sensors []*model.Sensor) *pgconn.PgError {
+	err := service.db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("session_id = ?", sessionId).Delete(&model.SessionSensor{}); result.Error != nil {
+			return result.Error
+		}
+		if len(sensors) == 0 {
+			return nil
+		}
+		snapshot := make([]*model.SessionSensor, len(sensors))
+		for i, sensor := range sensors {
+			snapshot[i] = model.NewSessionSensor(sessionId, sensor)
+		}
+		return tx.CreateInBatches(snapshot, 100).Error
+	})
+	if err != nil {
+		if perr := utils.GetPostgresError(err); perr != nil {
+			return perr
+		}
+		return &pgconn.PgError{Message: err.Error()}
+	}
+	return nil
+}
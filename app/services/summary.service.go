@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/utils"
+	"database-ms/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+type SummaryServiceInterface interface {
+	// Public
+	FindBySessionId(context.Context, uuid.UUID) ([]*model.SensorSummary, *pgconn.PgError)
+
+	// Private
+	Compute(context.Context, uuid.UUID) ([]*model.SensorSummary, *pgconn.PgError)
+}
+
+type SummaryService struct {
+	db     *gorm.DB
+	config *config.Configuration
+}
+
+func NewSummaryService(db *gorm.DB, c *config.Configuration) SummaryServiceInterface {
+	return &SummaryService{config: c, db: db}
+}
+
+// Statistics of each sensor in a session. Each value lasts until the sensor's
+// next value for the time past its thresholds, a threshold pair is only used
+// when upper > lower since unset thresholds are stored as zeros.
+const summaryQuery = `
+SELECT sensor_id,
+	COUNT(*) AS count,
+	MIN(value) AS min,
+	MAX(value) AS max,
+	AVG(value) AS mean,
+	COALESCE(STDDEV_POP(value), 0) AS stddev,
+	PERCENTILE_CONT(0.01) WITHIN GROUP (ORDER BY value) AS p1,
+	PERCENTILE_CONT(0.05) WITHIN GROUP (ORDER BY value) AS p5,
+	PERCENTILE_CONT(0.25) WITHIN GROUP (ORDER BY value) AS p25,
+	PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY value) AS p50,
+	PERCENTILE_CONT(0.75) WITHIN GROUP (ORDER BY value) AS p75,
+	PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY value) AS p95,
+	PERCENTILE_CONT(0.99) WITHIN GROUP (ORDER BY value) AS p99,
+	COALESCE(SUM(CASE WHEN in_warning AND NOT in_danger THEN duration END), 0) AS time_in_warning,
+	COALESCE(SUM(CASE WHEN in_danger THEN duration END), 0) AS time_in_danger
+FROM (
+	SELECT datum.sensor_id, datum.value,
+		COALESCE(LEAD(datum.timestamp) OVER (PARTITION BY datum.sensor_id ORDER BY datum.timestamp) - datum.timestamp, 0) AS duration,
+		COALESCE(sensor.upper_warning, 0) > COALESCE(sensor.lower_warning, 0)
+			AND (datum.value > sensor.upper_warning OR datum.value < sensor.lower_warning) AS in_warning,
+		COALESCE(sensor.upper_danger, 0) > COALESCE(sensor.lower_danger, 0)
+			AND (datum.value > sensor.upper_danger OR datum.value < sensor.lower_danger) AS in_danger
+	FROM datum_sample datum
+	JOIN session_sensor sensor ON sensor.session_id = datum.session_id AND sensor.sensor_id = datum.sensor_id
+	WHERE datum.session_id = ?
+) samples
+GROUP BY sensor_id`
+
+// PUBLIC FUNCTIONS
+
+func (service *SummaryService) FindBySessionId(ctx context.Context, sessionId uuid.UUID) ([]*model.SensorSummary, *pgconn.PgError) {
+	summaries := []*model.SensorSummary{}
+	result := service.db.Where("session_id = ?", sessionId).Find(&summaries)
+	if result.Error != nil {
+		return nil, utils.GetPostgresError(result.Error)
+	}
+	return summaries, nil
+}
+
+// PRIVATE FUNCTIONS
+
+// Computes a session's statistics from its data, replacing the ones stored.
+// The stored statistics are kept for sessions without data, such as sessions
+// whose data was removed by a retention policy.
+func (service *SummaryService) Compute(ctx context.Context, sessionId uuid.UUID) ([]*model.SensorSummary, *pgconn.PgError) {
+	// Guard against replacing the statistics of a session without data
+	extent, perr := NewDatumService(service.db, service.config).FindExtentBySessionId(ctx, sessionId)
+	if perr != nil {
+		return nil, perr
+	}
+	if extent.First == nil {
+		return service.FindBySessionId(ctx, sessionId)
+	}
+
+	summaries := []*model.SensorSummary{}
+	err := service.db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Raw(summaryQuery, sessionId).Scan(&summaries); result.Error != nil {
+			return result.Error
+		}
+		if result := tx.Where("session_id = ?", sessionId).Delete(&model.SensorSummary{}); result.Error != nil {
+			return result.Error
+		}
+		for _, summary := range summaries {
+			summary.SessionId = sessionId
+		}
+		if len(summaries) > 0 {
+			if result := tx.CreateInBatches(summaries, 100); result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if perr := utils.GetPostgresError(err); perr != nil {
+			return nil, perr
+		}
+		return nil, &pgconn.PgError{Message: err.Error()}
+	}
+	return summaries, nil
+}
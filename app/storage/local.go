@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage keeps the files in a directory of the local disk
+type LocalStorage struct {
+	root string
+}
+
+type localFile struct {
+	*os.File
+	info *Info
+}
+
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root: root}
+}
+
+func (storage *LocalStorage) Put(ctx context.Context, key string, content io.Reader, size int64) error {
+	path := storage.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+
+	// Write next to the file so it is replaced all at once
+	tempPath := path + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
+
+func (storage *LocalStorage) Open(ctx context.Context, key string) (File, error) {
+	file, err := os.Open(storage.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &localFile{File: file, info: &Info{Size: stat.Size(), ModTime: stat.ModTime()}}, nil
+}
+
+func (storage *LocalStorage) Stat(ctx context.Context, key string) (*Info, error) {
+	stat, err := os.Stat(storage.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Info{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (storage *LocalStorage) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(storage.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Prefixes ending with a slash remove the whole directory
+func (storage *LocalStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	if strings.HasSuffix(prefix, "/") {
+		return os.RemoveAll(storage.path(prefix))
+	}
+	matches, err := filepath.Glob(storage.path(prefix) + "*")
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		if err = os.RemoveAll(match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renames a local file to the key's path, nothing is done if it is already
+// there. Files on another disk are copied.
+func (storage *LocalStorage) move(key string, path string) error {
+	target := storage.path(key)
+	if filepath.Clean(target) == filepath.Clean(path) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+	err := os.Rename(path, target)
+	if _, ok := err.(*os.LinkError); !ok {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = storage.Put(context.Background(), key, file, 0); err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(path)
+}
+
+func (storage *LocalStorage) path(key string) string {
+	return filepath.Join(storage.root, filepath.FromSlash(key))
+}
+
+func (file *localFile) Info() *Info {
+	return file.info
+}
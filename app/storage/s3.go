@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Storage keeps the files in a bucket of an S3 compatible service such as
+// MinIO. Buckets are addressed by path.
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+// Reads an object with ranged requests, seeking starts a new request
+type s3File struct {
+	*minio.Object
+	info *Info
+}
+
+// The endpoint is the service's url, https is used when it is the scheme
+func NewS3Storage(endpoint string, region string, bucket string, accessKey string, secretKey string) (*S3Storage, error) {
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	client, err := minio.New(target.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure:       target.Scheme == "https",
+		Region:       region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Storage{client: client, bucket: bucket}, nil
+}
+
+func (storage *S3Storage) Put(ctx context.Context, key string, content io.Reader, size int64) error {
+	_, err := storage.client.PutObject(ctx, storage.bucket, key, content, size, minio.PutObjectOptions{})
+	return err
+}
+
+func (storage *S3Storage) Open(ctx context.Context, key string) (File, error) {
+	info, err := storage.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	object, err := storage.client.GetObject(ctx, storage.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return &s3File{Object: object, info: info}, nil
+}
+
+func (storage *S3Storage) Stat(ctx context.Context, key string) (*Info, error) {
+	object, err := storage.client.StatObject(ctx, storage.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return &Info{Size: object.Size, ModTime: object.LastModified}, nil
+}
+
+func (storage *S3Storage) Delete(ctx context.Context, key string) error {
+	err := s3Error(storage.client.RemoveObject(ctx, storage.bucket, key, minio.RemoveObjectOptions{}))
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Lists the keys and deletes them one by one
+func (storage *S3Storage) DeletePrefix(ctx context.Context, prefix string) error {
+	// Cancelled on return so the listing stops when a delete fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	objects := storage.client.ListObjects(ctx, storage.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	for object := range objects {
+		if object.Err != nil {
+			return s3Error(object.Err)
+		}
+		if err := storage.Delete(ctx, object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Missing objects are reported as ErrNotFound like the other backends
+func s3Error(err error) error {
+	if err != nil && minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (file *s3File) Info() *Info {
+	return file.info
+}
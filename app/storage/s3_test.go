@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Connects to the MinIO server set in S3_TEST_ENDPOINT, for example with
+// docker run -p 9000:9000 minio/minio server /data and the minioadmin keys
+func testS3Storage(t *testing.T) *S3Storage {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT is not set")
+	}
+	bucket := os.Getenv("S3_TEST_BUCKET")
+	if bucket == "" {
+		bucket = "srv-database-ms-test"
+	}
+	storage, err := NewS3Storage(endpoint, "us-east-1", bucket, os.Getenv("S3_TEST_ACCESS_KEY_ID"), os.Getenv("S3_TEST_SECRET_ACCESS_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	exists, err := storage.client.BucketExists(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		if err = storage.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return storage
+}
+
+func TestS3Storage(t *testing.T) {
+	storage := testS3Storage(t)
+	ctx := context.Background()
+	prefix := "test-" + strings.ReplaceAll(t.Name(), "/", "-") + "/"
+	key := prefix + "session.csv"
+	content := []byte("ts,1\n1,2\n3,4\n")
+	defer storage.DeletePrefix(ctx, prefix)
+
+	// Put then Stat
+	if err := storage.Put(ctx, key, bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	info, err := storage.Stat(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(content)) || info.ModTime.IsZero() {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	// Open reads from any offset
+	file, err := storage.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, content[5:]) || file.Info().Size != int64(len(content)) {
+		t.Fatalf("read %q, expected %q", rest, content[5:])
+	}
+
+	// Missing files
+	if _, err = storage.Stat(ctx, prefix+"missing.csv"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from Stat, got %v", err)
+	}
+	if _, err = storage.Open(ctx, prefix+"missing.csv"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from Open, got %v", err)
+	}
+	if err = storage.Delete(ctx, prefix+"missing.csv"); err != nil {
+		t.Fatalf("expected missing files to be ignored by Delete, got %v", err)
+	}
+
+	// Delete then DeletePrefix
+	if err = storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the file to be deleted, got %v", err)
+	}
+	for _, name := range []string{"a.csv", "b/c.csv"} {
+		if err = storage.Put(ctx, prefix+name, bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = storage.DeletePrefix(ctx, prefix); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.csv", "b/c.csv"} {
+		if _, err = storage.Stat(ctx, prefix+name); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected %s to be deleted, got %v", name, err)
+		}
+	}
+}
+
+func TestS3Error(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"no error", nil, nil},
+		{"missing key", minio.ErrorResponse{StatusCode: 404, Code: "NoSuchKey"}, ErrNotFound},
+		{"denied", minio.ErrorResponse{StatusCode: 403, Code: "AccessDenied"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := s3Error(test.err)
+			if test.expected != nil && !errors.Is(err, test.expected) || test.expected == nil && err != test.err {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestNewS3StorageScheme(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		scheme   string
+	}{
+		{"http://minio:9000", "http"},
+		{"https://s3.us-east-1.amazonaws.com", "https"},
+	}
+	for _, test := range tests {
+		t.Run(test.endpoint, func(t *testing.T) {
+			storage, err := NewS3Storage(test.endpoint, "us-east-1", "bucket", "key", "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if storage.client.EndpointURL().Scheme != test.scheme {
+				t.Fatalf("unexpected endpoint %s", storage.client.EndpointURL())
+			}
+		})
+	}
+}
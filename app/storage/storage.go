@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/config"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Backends selected with STORAGE_BACKEND
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// Archived files are gzipped under the file's key with this suffix
+const ArchiveExtension = ".gz"
+
+var ErrNotFound = errors.New("the file does not exist")
+
+// Storage keeps the sessions' files under keys such as "<thingId>/<sessionId>.csv"
+type Storage interface {
+	// Put stores size bytes of content under the key, replacing any file there
+	Put(ctx context.Context, key string, content io.Reader, size int64) error
+	// Open reads a file, ErrNotFound if there is none
+	Open(ctx context.Context, key string) (File, error)
+	// Stat describes a file, ErrNotFound if there is none
+	Stat(ctx context.Context, key string) (*Info, error)
+	// Delete removes a file, missing files are ignored
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every file whose key starts with the prefix
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// File is a stored file that can be read from any offset
+type File interface {
+	io.ReadSeekCloser
+	Info() *Info
+}
+
+// NewStorage opens the backend chosen in the configuration, files are kept
+// on the local disk under the file path unless S3 is chosen. It panics when
+// the S3 endpoint is invalid.
+func NewStorage(conf *config.Configuration) Storage {
+	if conf.StorageBackend == BackendS3 {
+		s3, err := NewS3Storage(conf.S3Endpoint, conf.S3Region, conf.S3Bucket, conf.S3AccessKey, conf.S3SecretKey)
+		if err != nil {
+			panic(err)
+		}
+		return s3
+	}
+	return NewLocalStorage(conf.FilePath)
+}
+
+// SessionKey is where a session's csv file is stored
+func SessionKey(session *model.Session) string {
+	return Key(session.ThingId, session.Id)
+}
+
+// Key is where the csv file of a thing's session is stored, the session's id
+// is used as its name can change
+func Key(thingId uuid.UUID, sessionId uuid.UUID) string {
+	return thingId.String() + "/" + sessionId.String() + ".csv"
+}
+
+// ThingPrefix is the start of the keys of a thing's files
+func ThingPrefix(thingId string) string {
+	return thingId + "/"
+}
+
+// WorkingPath is where a session's csv file is written on the local disk
+// before it is stored, it is the stored file itself for local storage
+func WorkingPath(conf *config.Configuration, session *model.Session) string {
+	return conf.FilePath + SessionKey(session)
+}
+
+// PutFile moves a file of the local disk into the storage
+func PutFile(ctx context.Context, storage Storage, key string, path string) error {
+	if local, ok := storage.(*LocalStorage); ok {
+		return local.move(key, path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err = storage.Put(ctx, key, file, info.Size()); err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(path)
+}
+
+// FileSize is the size of a file or of its archive, zero if there is neither
+func FileSize(ctx context.Context, storage Storage, key string) (size int64, archived bool) {
+	if info, err := storage.Stat(ctx, key); err == nil {
+		return info.Size, false
+	}
+	if info, err := storage.Stat(ctx, key+ArchiveExtension); err == nil {
+		return info.Size, true
+	}
+	return 0, false
+}
@@ -0,0 +1,141 @@
+package subscriber
+
+import (
+	"database-ms/app/model"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+type alarmThresholds struct {
+	sensorId     uuid.UUID
+	hasWarning   bool
+	lowerWarning float64
+	upperWarning float64
+	hasDanger    bool
+	lowerDanger  float64
+	upperDanger  float64
+}
+
+// AlarmDetector follows each sensor's level against its warning and danger
+// thresholds, keeping the active alarms from one chunk to the next. Unset
+// thresholds are stored as zeros so a pair is only used when upper > lower.
+type AlarmDetector struct {
+	sessionId  uuid.UUID
+	thresholds map[string]alarmThresholds
+	active     map[string]*model.Alarm
+	dirty      map[*model.Alarm]bool
+}
+
+func NewAlarmDetector(sessionId uuid.UUID, sensors []*model.Sensor) *AlarmDetector {
+	detector := &AlarmDetector{
+		sessionId:  sessionId,
+		thresholds: make(map[string]alarmThresholds),
+		active:     make(map[string]*model.Alarm),
+		dirty:      make(map[*model.Alarm]bool),
+	}
+	for _, sensor := range sensors {
+		thresholds := alarmThresholds{
+			sensorId:     sensor.Id,
+			hasWarning:   sensor.UpperWarning > sensor.LowerWarning,
+			lowerWarning: sensor.LowerWarning,
+			upperWarning: sensor.UpperWarning,
+			hasDanger:    sensor.UpperDanger > sensor.LowerDanger,
+			lowerDanger:  sensor.LowerDanger,
+			upperDanger:  sensor.UpperDanger,
+		}
+		if thresholds.hasWarning || thresholds.hasDanger {
+			detector.thresholds[strconv.Itoa(sensor.SmallId)] = thresholds
+		}
+	}
+	return detector
+}
+
+// Resume continues alarms that were active when a session was interrupted
+func (detector *AlarmDetector) Resume(alarms []*model.Alarm) {
+	for smallId, thresholds := range detector.thresholds {
+		for _, alarm := range alarms {
+			if alarm.SensorId == thresholds.sensorId && alarm.EndTime == nil {
+				detector.active[smallId] = alarm
+			}
+		}
+	}
+}
+
+// Check follows a sensor's value, returns the alarms that started or ended
+func (detector *AlarmDetector) Check(smallId string, timestamp int64, value float64) []*model.Alarm {
+	thresholds, ok := detector.thresholds[smallId]
+	if !ok {
+		return nil
+	}
+	level, direction, threshold := thresholds.classify(value)
+
+	// Follow the peak of an alarm that is still active
+	active := detector.active[smallId]
+	if active != nil && active.Level == level && active.Direction == direction {
+		if (direction == model.AlarmDirectionUpper && value > active.Peak) ||
+			(direction == model.AlarmDirectionLower && value < active.Peak) {
+			active.Peak = value
+			detector.dirty[active] = true
+		}
+		return nil
+	}
+
+	// The level changed, end the active alarm and start the next one
+	var changed []*model.Alarm
+	if active != nil {
+		endTime := timestamp
+		active.EndTime = &endTime
+		detector.dirty[active] = true
+		delete(detector.active, smallId)
+		changed = append(changed, active)
+	}
+	if level != "" {
+		alarm := &model.Alarm{
+			SessionId: detector.sessionId,
+			SensorId:  thresholds.sensorId,
+			Level:     level,
+			Direction: direction,
+			Threshold: threshold,
+			StartTime: timestamp,
+			Peak:      value,
+		}
+		detector.active[smallId] = alarm
+		detector.dirty[alarm] = true
+		changed = append(changed, alarm)
+	}
+	return changed
+}
+
+// Dirty returns the alarms changed since they were last saved
+func (detector *AlarmDetector) Dirty() []*model.Alarm {
+	var alarms []*model.Alarm
+	for alarm := range detector.dirty {
+		alarms = append(alarms, alarm)
+	}
+	return alarms
+}
+
+// Saved marks alarms as saved
+func (detector *AlarmDetector) Saved(alarms []*model.Alarm) {
+	for _, alarm := range alarms {
+		delete(detector.dirty, alarm)
+	}
+}
+
+// Returns the level, direction and threshold a value is past, danger first
+func (thresholds alarmThresholds) classify(value float64) (string, string, float64) {
+	if thresholds.hasDanger && value > thresholds.upperDanger {
+		return model.AlarmLevelDanger, model.AlarmDirectionUpper, thresholds.upperDanger
+	}
+	if thresholds.hasDanger && value < thresholds.lowerDanger {
+		return model.AlarmLevelDanger, model.AlarmDirectionLower, thresholds.lowerDanger
+	}
+	if thresholds.hasWarning && value > thresholds.upperWarning {
+		return model.AlarmLevelWarning, model.AlarmDirectionUpper, thresholds.upperWarning
+	}
+	if thresholds.hasWarning && value < thresholds.lowerWarning {
+		return model.AlarmLevelWarning, model.AlarmDirectionLower, thresholds.lowerWarning
+	}
+	return "", "", 0
+}
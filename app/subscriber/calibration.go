@@ -0,0 +1,69 @@
+package subscriber
+
+import (
+	"database-ms/app/model"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Calibrator converts the raw values of samples keyed by small id to
+// engineering units
+type Calibrator struct {
+	// Calibration versions of every sensor ordered by version
+	history      map[string][]*model.SensorCalibration
+	calibrations map[string]*model.SensorCalibration
+}
+
+// NewCalibrator uses the calibration currently set on each sensor
+func NewCalibrator(sensors []*model.Sensor) *Calibrator {
+	return NewCalibratorWithHistory(sensors, nil)
+}
+
+// NewCalibratorWithHistory keeps the calibration versions of the sensors so
+// that data can be converted with the calibration in effect when it was
+// recorded, see At. The current calibration is used until then and for
+// sensors without any version.
+func NewCalibratorWithHistory(sensors []*model.Sensor, history []*model.SensorCalibration) *Calibrator {
+	calibrator := &Calibrator{
+		history:      make(map[string][]*model.SensorCalibration),
+		calibrations: make(map[string]*model.SensorCalibration),
+	}
+	keys := make(map[uuid.UUID]string)
+	for _, sensor := range sensors {
+		if !sensor.IsDerived() {
+			keys[sensor.Id] = strconv.Itoa(sensor.SmallId)
+			calibrator.calibrations[keys[sensor.Id]] = model.CalibrationOf(sensor)
+		}
+	}
+	for _, calibration := range history {
+		if key, ok := keys[calibration.SensorId]; ok {
+			calibrator.history[key] = append(calibrator.history[key], calibration)
+		}
+	}
+	return calibrator
+}
+
+// At switches to the calibrations in effect at a time in milliseconds. Sensors
+// calibrated after the time get their first calibration.
+func (calibrator *Calibrator) At(time int64) {
+	for key, versions := range calibrator.history {
+		inEffect := versions[0]
+		for _, version := range versions[1:] {
+			if version.ValidFrom <= time {
+				inEffect = version
+			}
+		}
+		calibrator.calibrations[key] = inEffect
+	}
+}
+
+// Calibrate converts the sensor values of a sample in place, the timestamp and
+// values of unknown or derived sensors are left as they are
+func (calibrator *Calibrator) Calibrate(sample map[string]float64) {
+	for key, value := range sample {
+		if calibration, ok := calibrator.calibrations[key]; ok {
+			sample[key] = calibration.Apply(value)
+		}
+	}
+}
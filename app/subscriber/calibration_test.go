@@ -0,0 +1,90 @@
+package subscriber
+
+import (
+	"database-ms/app/model"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCalibrateConvertsEverySensorValue(t *testing.T) {
+	sensors := []*model.Sensor{
+		{SmallId: 1, Type: "H", CanId: 0x100, ConversionMultiplier: 0.5},
+		{SmallId: 2, Type: model.SensorTypeDerived, Expression: "1 * 2", ConversionMultiplier: 3},
+	}
+	calibrator := NewCalibrator(sensors)
+
+	tests := []struct {
+		name     string
+		sample   map[string]float64
+		expected map[string]float64
+	}{
+		{"json sample", map[string]float64{"ts": 10, "1": 10}, map[string]float64{"ts": 10, "1": 5}},
+		{"derived and unknown sensors", map[string]float64{"ts": 10, "2": 4, "9": 4}, map[string]float64{"ts": 10, "2": 4, "9": 4}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calibrator.Calibrate(test.sample)
+			for key, value := range test.expected {
+				if test.sample[key] != value {
+					t.Fatalf("expected %s to be %v, got %v", key, value, test.sample[key])
+				}
+			}
+		})
+	}
+
+	// Samples decoded from CAN frames are calibrated alike
+	id, timestamp := int64(0x100), float64(1)
+	sample, ok := NewCanDecoder(sensors).Decode(&CanFrame{Id: &id, Timestamp: &timestamp, Data: []byte{10, 0}})
+	if !ok {
+		t.Fatal("expected the frame to be decoded")
+	}
+	calibrator.Calibrate(sample)
+	if sample["1"] != 5 {
+		t.Fatalf("expected the decoded value to be calibrated to 5, got %v", sample["1"])
+	}
+}
+
+func TestCalibratorAt(t *testing.T) {
+	sensor := &model.Sensor{SmallId: 1, Type: "d", ConversionMultiplier: 10}
+	sensor.Id = uuid.New()
+	other := &model.Sensor{SmallId: 2, Type: "d", ConversionMultiplier: 7}
+	other.Id = uuid.New()
+	history := []*model.SensorCalibration{
+		{SensorId: sensor.Id, Version: 1, ValidFrom: 1000, ConversionMultiplier: 2},
+		{SensorId: sensor.Id, Version: 2, ValidFrom: 2000, ConversionMultiplier: 4},
+		{SensorId: sensor.Id, Version: 3, ValidFrom: 3000, ConversionMultiplier: 10},
+	}
+	calibrator := NewCalibratorWithHistory([]*model.Sensor{sensor, other}, history)
+
+	// The current calibration is used until a time is set
+	sample := map[string]float64{"ts": 1, "1": 1}
+	calibrator.Calibrate(sample)
+	if sample["1"] != 10 {
+		t.Fatalf("expected the current calibration, got %v", sample["1"])
+	}
+
+	tests := []struct {
+		name     string
+		time     int64
+		expected float64
+	}{
+		{"before the first version", 500, 2},
+		{"first version", 1500, 2},
+		{"from a version's start", 2000, 4},
+		{"last version", 5000, 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calibrator.At(test.time)
+			sample := map[string]float64{"ts": 1, "1": 1, "2": 1}
+			calibrator.Calibrate(sample)
+			if sample["1"] != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, sample["1"])
+			}
+			if sample["2"] != 7 {
+				t.Fatalf("expected the sensor without history to keep its calibration, got %v", sample["2"])
+			}
+		})
+	}
+}
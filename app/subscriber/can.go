@@ -0,0 +1,131 @@
+package subscriber
+
+import (
+	"database-ms/app/model"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Sizes in bytes of the sensor types, which are named after python's struct
+// format characters. Values are little endian.
+var canTypeSizes = map[string]int{
+	"?": 1, "c": 1, "b": 1, "B": 1,
+	"h": 2, "H": 2,
+	"i": 4, "I": 4, "f": 4,
+	"q": 8, "Q": 8, "d": 8,
+}
+
+// CanFrame is a raw frame pushed by a thing instead of a decoded sample
+type CanFrame struct {
+	Timestamp *float64 `json:"ts"`
+	Id        *int64   `json:"id"`
+	Data      CanData  `json:"data"`
+}
+
+// CanData is a frame's payload, sent as a hex string or an array of bytes
+type CanData []byte
+
+func (data *CanData) UnmarshalJSON(raw []byte) error {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		decoded, err := hex.DecodeString(text)
+		if err != nil {
+			return err
+		}
+		*data = decoded
+	} else {
+		var values []uint8
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return err
+		}
+		*data = values
+	}
+	if len(*data) > 8 {
+		return errors.New("can frames hold at most 8 bytes")
+	}
+	return nil
+}
+
+type canSignal struct {
+	smallId string
+	offset  int
+	kind    string
+}
+
+// CanDecoder turns raw frames into samples of raw values using the CAN ID,
+// offset and type of the thing's sensors
+type CanDecoder struct {
+	signals map[int64][]canSignal
+}
+
+func NewCanDecoder(sensors []*model.Sensor) *CanDecoder {
+	decoder := &CanDecoder{signals: make(map[int64][]canSignal)}
+	for _, sensor := range sensors {
+		if _, ok := canTypeSizes[sensor.Type]; !ok {
+			continue
+		}
+		decoder.signals[sensor.CanId] = append(decoder.signals[sensor.CanId], canSignal{
+			smallId: strconv.Itoa(sensor.SmallId),
+			offset:  sensor.CanOffset,
+			kind:    sensor.Type,
+		})
+	}
+	return decoder
+}
+
+// Decodes a frame into a sample keyed by small id.
+// Frames with an ID that no sensor uses are ignored, signals that do not fit
+// in the frame are skipped.
+func (decoder *CanDecoder) Decode(frame *CanFrame) (map[string]float64, bool) {
+	sample := map[string]float64{"ts": *frame.Timestamp}
+	for _, signal := range decoder.signals[*frame.Id] {
+		size := canTypeSizes[signal.kind]
+		if signal.offset < 0 || signal.offset+size > len(frame.Data) {
+			continue
+		}
+		sample[signal.smallId] = decodeCanValue(signal.kind, frame.Data[signal.offset:signal.offset+size])
+	}
+	return sample, len(sample) > 1
+}
+
+func decodeCanValue(kind string, data []byte) float64 {
+	switch kind {
+	case "?":
+		if data[0] != 0 {
+			return 1
+		}
+		return 0
+	case "b":
+		return float64(int8(data[0]))
+	case "c", "B":
+		return float64(data[0])
+	case "h":
+		return float64(int16(binary.LittleEndian.Uint16(data)))
+	case "H":
+		return float64(binary.LittleEndian.Uint16(data))
+	case "i":
+		return float64(int32(binary.LittleEndian.Uint32(data)))
+	case "I":
+		return float64(binary.LittleEndian.Uint32(data))
+	case "q":
+		return float64(int64(binary.LittleEndian.Uint64(data)))
+	case "Q":
+		return float64(binary.LittleEndian.Uint64(data))
+	case "f":
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+	default:
+		return math.Float64frombits(binary.LittleEndian.Uint64(data))
+	}
+}
+
+func parseCanFrame(thingDataItem string) (*CanFrame, bool) {
+	var frame CanFrame
+	if err := json.Unmarshal([]byte(thingDataItem), &frame); err != nil {
+		return nil, false
+	}
+	return &frame, frame.Timestamp != nil && frame.Id != nil && frame.Data != nil
+}
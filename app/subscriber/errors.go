@@ -0,0 +1,107 @@
+package subscriber
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"database-ms/config"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Kinds of ingestion errors
+const (
+	ErrBadPayload   = "badPayload"
+	ErrUnknownThing = "unknownThing"
+	ErrDatabase     = "databaseFailure"
+	ErrFile         = "fileFailure"
+	ErrInternal     = "internalError"
+)
+
+// IngestError classifies an error that happened while ingesting thing data
+type IngestError struct {
+	Kind string
+	Err  error
+}
+
+func NewIngestError(kind string, err error) *IngestError {
+	return &IngestError{Kind: kind, Err: err}
+}
+
+func (e *IngestError) Error() string {
+	return e.Kind + ": " + e.Err.Error()
+}
+
+func (e *IngestError) Unwrap() error {
+	return e.Err
+}
+
+// DeadLetterStore preserves payloads that could not be ingested. They are
+// saved to the dead letter table, or to a file if the database is unavailable.
+type DeadLetterStore struct {
+	service  services.DeadLetterServiceInterface
+	filePath string
+}
+
+func NewDeadLetterStore(db *gorm.DB, conf *config.Configuration) *DeadLetterStore {
+	return &DeadLetterStore{
+		service:  services.NewDeadLetterService(db, conf),
+		filePath: conf.FilePath + "dead_letter/",
+	}
+}
+
+func (store *DeadLetterStore) Save(
+	ctx context.Context,
+	thingId *uuid.UUID,
+	sessionId *uuid.UUID,
+	err *IngestError,
+	payloads []string,
+) {
+	log.Println("Ingestion failed, saving " + err.Error())
+	if len(payloads) == 0 {
+		return
+	}
+	deadLetter := &model.DeadLetter{
+		Kind:      err.Kind,
+		Error:     err.Err.Error(),
+		Payload:   strings.Join(payloads, "\n"),
+		Time:      time.Now().UnixMilli(),
+		ThingId:   thingId,
+		SessionId: sessionId,
+	}
+	perr := store.service.Create(ctx, deadLetter)
+	if perr == nil {
+		return
+	}
+
+	// Fall back to a file with one dead letter per line
+	log.Println("Failed to save dead letter, writing it to a file: " + perr.Error())
+	if ferr := store.saveToFile(deadLetter); ferr != nil {
+		log.Println("Failed to write dead letter file: " + ferr.Error())
+		log.Println(deadLetter.Payload)
+	}
+}
+
+func (store *DeadLetterStore) saveToFile(deadLetter *model.DeadLetter) error {
+	line, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(store.filePath, 0777); err != nil {
+		return err
+	}
+	fileName := store.filePath + time.Now().Format("2006-01-02") + ".jsonl"
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,242 @@
+package subscriber
+
+import (
+	"context"
+	"database-ms/app/derived"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Number of csv rows inserted at a time when importing
+const importBatchRows = 1000
+
+// CsvImport describes the data read from a session csv file
+type CsvImport struct {
+	Rows           int
+	Samples        int
+	FirstTimestamp int64
+	LastTimestamp  int64
+}
+
+// ValidateCsv checks that a session csv file can be imported for the sensors
+// without writing anything
+func ValidateCsv(reader io.Reader, sensors []*model.Sensor) (*CsvImport, error) {
+	return readCsv(reader, sensors, uuid.Nil, nil)
+}
+
+// ImportCsv replaces the data of a session with the data in a csv file. The
+// header must be "Timestamp" followed by sensor names of the session's thing.
+// Empty cells are left without a value and repeated timestamps are skipped.
+// Derived sensors missing from the file are computed from the last values of
+// their inputs on every row where one of their inputs has a value.
+func ImportCsv(
+	ctx context.Context,
+	reader io.Reader,
+	session *model.Session,
+	sensors []*model.Sensor,
+	datumService services.DatumServiceInterface,
+) (*CsvImport, error) {
+	// Replace the data of any previous upload in one transaction, nothing is
+	// changed if anything fails
+	var result *CsvImport
+	var readErr error
+	perr := datumService.ReplaceBySessionId(ctx, nil, session.Id, func(insert func([]*model.Datum) error) error {
+		result, readErr = readCsv(reader, sensors, session.Id, func(datumArray []*model.Datum) error {
+			if err := insert(datumArray); err != nil {
+				return NewIngestError(ErrDatabase, err)
+			}
+			return nil
+		})
+		return readErr
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+	if perr != nil {
+		return nil, NewIngestError(ErrDatabase, perr)
+	}
+
+	// Merge the chunks of the batches, the data is readable either way
+	if perr = datumService.CompactBySessionId(ctx, session.Id); perr != nil {
+		log.Println("Failed to compact session " + session.Id.String() + ": " + perr.Error())
+	}
+	return result, nil
+}
+
+func readCsv(
+	reader io.Reader,
+	sensors []*model.Sensor,
+	sessionId uuid.UUID,
+	insert func([]*model.Datum) error,
+) (*CsvImport, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.ReuseRecord = true
+
+	// Attempt to match the header against the sensor names
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, errors.New("the file has no header")
+	}
+	if len(header) < 2 || !strings.EqualFold(strings.TrimSpace(header[0]), "Timestamp") {
+		return nil, errors.New("the header must start with Timestamp followed by sensor names")
+	}
+	sensorsByName := make(map[string]*model.Sensor)
+	for _, sensor := range sensors {
+		sensorsByName[sensor.Name] = sensor
+	}
+	var columns []*model.Sensor
+	seen := make(map[string]bool)
+	for _, name := range header[1:] {
+		name = strings.TrimSpace(name)
+		sensor, ok := sensorsByName[name]
+		if !ok {
+			return nil, errors.New("sensor " + name + " does not exist")
+		}
+		if seen[name] {
+			return nil, errors.New("sensor " + name + " appears more than once")
+		}
+		seen[name] = true
+		columns = append(columns, sensor)
+	}
+
+	// Attempt to compile the derived sensors that are not in the file
+	var missingDerived []*model.Sensor
+	sensorIds := make(map[string]uuid.UUID)
+	for _, sensor := range sensors {
+		sensorIds[strconv.Itoa(sensor.SmallId)] = sensor.Id
+		if sensor.IsDerived() && !seen[sensor.Name] {
+			missingDerived = append(missingDerived, sensor)
+		}
+	}
+	var derivedSet *derived.Set
+	if len(missingDerived) > 0 {
+		inputs := append([]*model.Sensor{}, missingDerived...)
+		for _, sensor := range sensors {
+			if !sensor.IsDerived() || seen[sensor.Name] {
+				inputs = append(inputs, asInput(sensor))
+			}
+		}
+		derivedSet, err = derived.Compile(inputs)
+		if err != nil {
+			return nil, errors.New("derived sensor " + err.Error())
+		}
+	}
+	currentValues := make(map[string]float64)
+
+	// Read the rows, timestamps must be increasing
+	result := &CsvImport{}
+	var datumArray []*model.Datum
+	batchRows := 0
+	for line := 2; ; line++ {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) != len(columns)+1 {
+			return nil, fmt.Errorf("line %d has %d columns instead of %d", line, len(row), len(columns)+1)
+		}
+		timestampValue, err := strconv.ParseFloat(strings.TrimSpace(row[0]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d has an invalid timestamp", line)
+		}
+		timestamp := int64(timestampValue)
+		if result.Rows > 0 && timestamp < result.LastTimestamp {
+			return nil, fmt.Errorf("line %d has a timestamp before the previous line", line)
+		}
+		if result.Rows > 0 && timestamp == result.LastTimestamp {
+			continue
+		}
+		sample := make(map[string]float64)
+		for i, cell := range row[1:] {
+			cell = strings.TrimSpace(cell)
+			if cell == "" {
+				continue
+			}
+			value, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d has an invalid value for %s", line, strings.TrimSpace(header[i+1]))
+			}
+			sample[strconv.Itoa(columns[i].SmallId)] = value
+			currentValues[strconv.Itoa(columns[i].SmallId)] = value
+			result.Samples++
+			if insert != nil {
+				datumArray = append(datumArray, &model.Datum{
+					SessionId: sessionId,
+					SensorId:  columns[i].Id,
+					Value:     value,
+					Timestamp: timestamp,
+				})
+			}
+		}
+
+		// Compute the derived sensors that are not in the file
+		if derivedSet != nil && len(sample) > 0 {
+			for _, key := range derivedSet.Apply(currentValues) {
+				if !derivedSet.Reads(key, sample) {
+					continue
+				}
+				sample[key] = currentValues[key]
+				result.Samples++
+				if insert != nil {
+					datumArray = append(datumArray, &model.Datum{
+						SessionId: sessionId,
+						SensorId:  sensorIds[key],
+						Value:     currentValues[key],
+						Timestamp: timestamp,
+					})
+				}
+			}
+		}
+		if result.Rows == 0 {
+			result.FirstTimestamp = timestamp
+		}
+		result.LastTimestamp = timestamp
+		result.Rows++
+
+		// Insert a full batch
+		batchRows++
+		if insert != nil && batchRows == importBatchRows {
+			if err = insert(datumArray); err != nil {
+				return nil, err
+			}
+			datumArray = nil
+			batchRows = 0
+		}
+	}
+	if result.Rows == 0 {
+		return nil, errors.New("the file has no data")
+	}
+
+	// Insert the last batch
+	if insert != nil && len(datumArray) > 0 {
+		if err = insert(datumArray); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// A derived sensor whose column is in the file keeps the file's values, it is
+// compiled as a plain double so the derived sensors missing from the file
+// read it instead of computing its expression
+func asInput(sensor *model.Sensor) *model.Sensor {
+	if !sensor.IsDerived() {
+		return sensor
+	}
+	input := *sensor
+	input.Type = model.SensorTypeDouble
+	input.Expression = ""
+	return &input
+}
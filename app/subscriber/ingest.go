@@ -0,0 +1,474 @@
+package subscriber
+
+import (
+	"context"
+	"database-ms/app/derived"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+// SessionIngest writes the data of a session to the database and its csv
+// file in chunks. Only the samples that were sent are written, the last value
+// of every sensor is carried from one chunk to the next to compute the
+// derived sensors.
+type SessionIngest struct {
+	sessionId        uuid.UUID
+	smallIds         []int
+	smallIdToInfoMap map[string]SensorInfo
+	sensors          []*model.Sensor
+	derived          *derived.Set
+	calibrator       *Calibrator
+	datumService     services.DatumServiceInterface
+
+	// Transaction the data is written through, nil to copy every chunk in a
+	// transaction of its own
+	tx *gorm.DB
+
+	// Last value of every sensor
+	currentDataMap map[string]float64
+
+	// Csv output
+	csvFile   *os.File
+	csvWriter *csv.Writer
+
+	// Raw data retention, nil when it is off
+	rawChunkService services.RawChunkServiceInterface
+
+	// Alarm detection, nil when it is off
+	alarms       *AlarmDetector
+	alarmService services.AlarmServiceInterface
+	onAlarm      func(*model.Alarm)
+
+	// Number of samples written
+	Count         int
+	LastTimestamp int64
+
+	// Entries of the thing's redis list written so far, see ProcessFlushed
+	FlushedEntries int64
+}
+
+func NewSessionIngest(
+	session *model.Session,
+	sensors []*model.Sensor,
+	datumService services.DatumServiceInterface,
+	filePath string,
+	fileName string,
+) (*SessionIngest, error) {
+	// Order the sensors by name so the csv columns are stable
+	sensors = append([]*model.Sensor{}, sensors...)
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].Name < sensors[j].Name
+	})
+	ingest := newSessionIngest(session, sensors, datumService)
+
+	// Attempt to create the csv file and write the header
+	err := os.MkdirAll(filePath, 0777)
+	if err != nil {
+		return nil, err
+	}
+	ingest.csvFile, err = os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	ingest.csvWriter = csv.NewWriter(ingest.csvFile)
+	header := []string{"Timestamp"}
+	for _, smallId := range ingest.smallIds {
+		header = append(header, ingest.smallIdToInfoMap[strconv.Itoa(smallId)].Name)
+	}
+	if err = ingest.csvWriter.Write(header); err != nil {
+		ingest.csvFile.Close()
+		return nil, err
+	}
+	ingest.csvWriter.Flush()
+	return ingest, ingest.csvWriter.Error()
+}
+
+// ResumeSessionIngest continues a session whose csv file was already started,
+// restoring the columns and the sensors' last values from the file. A new ingest is
+// started if the file does not exist.
+func ResumeSessionIngest(
+	session *model.Session,
+	sensors []*model.Sensor,
+	datumService services.DatumServiceInterface,
+	filePath string,
+	fileName string,
+) (*SessionIngest, error) {
+	csvFile, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return NewSessionIngest(session, sensors, datumService, filePath, fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the header and the last value of every column
+	csvReader := csv.NewReader(csvFile)
+	csvReader.ReuseRecord = true
+	header, err := csvReader.Read()
+	if err != nil {
+		csvFile.Close()
+		return NewSessionIngest(session, sensors, datumService, filePath, fileName)
+	}
+	header = append([]string{}, header...)
+	lastValues := make([]string, len(header))
+	for {
+		row, err := csvReader.Read()
+		if err != nil {
+			break
+		}
+		if len(row) != len(header) {
+			continue
+		}
+		for i, cell := range row {
+			if cell != "" {
+				lastValues[i] = cell
+			}
+		}
+	}
+	csvFile.Close()
+
+	// Order the sensors like the existing columns, sensors added since are left out
+	sensorsByName := make(map[string]*model.Sensor)
+	for _, sensor := range sensors {
+		sensorsByName[sensor.Name] = sensor
+	}
+	var columnSensors []*model.Sensor
+	for _, name := range header[1:] {
+		sensor, ok := sensorsByName[name]
+		if !ok {
+			return nil, errors.New("sensor " + name + " of the session file no longer exists")
+		}
+		columnSensors = append(columnSensors, sensor)
+	}
+	ingest := newSessionIngest(session, columnSensors, datumService)
+
+	// Restore the last values, derived sensors are computed again
+	for i, smallId := range ingest.smallIds {
+		key := strconv.Itoa(smallId)
+		if ingest.derived.IsDerived(key) {
+			continue
+		}
+		if value, err := strconv.ParseFloat(lastValues[i+1], 64); err == nil {
+			ingest.currentDataMap[key] = value
+		}
+	}
+
+	// Attempt to open the file for appending
+	ingest.csvFile, err = os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	ingest.csvWriter = csv.NewWriter(ingest.csvFile)
+	return ingest, nil
+}
+
+func newSessionIngest(
+	session *model.Session,
+	sensors []*model.Sensor,
+	datumService services.DatumServiceInterface,
+) *SessionIngest {
+	ingest := &SessionIngest{
+		sessionId:        session.Id,
+		smallIdToInfoMap: make(map[string]SensorInfo),
+		sensors:          sensors,
+		datumService:     datumService,
+		currentDataMap:   make(map[string]float64),
+		FlushedEntries:   session.FlushedEntries,
+	}
+
+	// Attempt to compile the derived sensors, they are left empty if they cannot be
+	derivedSet, err := derived.Compile(sensors)
+	if err != nil {
+		log.Println("Derived sensors of session " + session.Id.String() + " are not computed: " + err.Error())
+		derivedSet, _ = derived.Compile(nil)
+	}
+	ingest.derived = derivedSet
+	ingest.calibrator = NewCalibrator(sensors)
+
+	// Get small ids in the respective order of the sensors
+	for _, sensor := range sensors {
+		ingest.smallIds = append(ingest.smallIds, sensor.SmallId)
+		smallId := fmt.Sprint(sensor.SmallId)
+		ingest.smallIdToInfoMap[smallId] = SensorInfo{Id: sensor.Id, Name: sensor.Name}
+	}
+	return ingest
+}
+
+// DetectAlarms checks the samples against the sensors' warning and danger
+// thresholds, continuing the alarms that are still open for the session.
+// onAlarm is called with every alarm that starts or ends.
+func (ingest *SessionIngest) DetectAlarms(ctx context.Context, alarmService services.AlarmServiceInterface, onAlarm func(*model.Alarm)) error {
+	openAlarms, perr := alarmService.FindOpenBySessionId(ctx, ingest.sessionId)
+	if perr != nil {
+		return perr
+	}
+	ingest.alarms = NewAlarmDetector(ingest.sessionId, ingest.sensors)
+	ingest.alarms.Resume(openAlarms)
+	ingest.alarmService = alarmService
+	ingest.onAlarm = onAlarm
+	return nil
+}
+
+// KeepRawData stores every chunk as the thing sent it alongside the
+// calibrated data so the session can be processed again later
+func (ingest *SessionIngest) KeepRawData(rawChunkService services.RawChunkServiceInterface) {
+	ingest.rawChunkService = rawChunkService
+}
+
+// UseCalibrationHistory converts the data with the calibration in effect when
+// it was recorded instead of the current one, see Calibrator.At
+func (ingest *SessionIngest) UseCalibrationHistory(history []*model.SensorCalibration) {
+	ingest.calibrator = NewCalibratorWithHistory(ingest.sensors, history)
+}
+
+// UseTransaction writes the data through tx so that it is only kept if tx
+// commits
+func (ingest *SessionIngest) UseTransaction(tx *gorm.DB) {
+	ingest.tx = tx
+}
+
+// Calibrator converts the sensor values like the ingest does
+func (ingest *SessionIngest) Calibrator() *Calibrator {
+	return ingest.calibrator
+}
+
+// Process calibrates, exports and stores a chunk of thing data. The csv rows
+// are written first and removed again if the data cannot be stored, nothing
+// is kept from a chunk that fails so that it can be processed again.
+func (ingest *SessionIngest) Process(ctx context.Context, thingDataArray []map[string]float64) *IngestError {
+	return ingest.process(ctx, thingDataArray, nil)
+}
+
+// ProcessFlushed processes a chunk read from the thing's redis list like
+// Process and stores flushedEntries, the count of list entries written once
+// the chunk is, in the same transaction as the data. The count is kept even
+// when none of the entries had a sample.
+func (ingest *SessionIngest) ProcessFlushed(ctx context.Context, thingDataArray []map[string]float64, flushedEntries int64) *IngestError {
+	return ingest.process(ctx, thingDataArray, &flushedEntries)
+}
+
+func (ingest *SessionIngest) process(ctx context.Context, thingDataArray []map[string]float64, flushedEntries *int64) *IngestError {
+	if len(thingDataArray) == 0 {
+		if perr := ingest.store(ctx, nil, flushedEntries); perr != nil {
+			return NewIngestError(ErrDatabase, perr)
+		}
+		return nil
+	}
+
+	// Sort the thing data by timestamp
+	sort.Slice(thingDataArray, func(i, j int) bool {
+		return thingDataArray[i]["ts"] < thingDataArray[j]["ts"]
+	})
+
+	// Ignore values sent for derived sensors, they are computed below
+	for _, thingDataItem := range thingDataArray {
+		for key := range thingDataItem {
+			if ingest.derived.IsDerived(key) {
+				delete(thingDataItem, key)
+			}
+		}
+	}
+
+	// Encode the raw chunk before it is calibrated
+	var rawChunk *model.RawChunk
+	if ingest.rawChunkService != nil {
+		rawChunk = ingest.newRawChunk(thingDataArray)
+	}
+
+	// Convert the raw values to engineering units
+	for _, thingDataItem := range thingDataArray {
+		ingest.calibrator.Calibrate(thingDataItem)
+	}
+
+	// Keep the values that were sent for alarm detection
+	var readings []alarmReading
+	if ingest.alarms != nil {
+		for _, thingDataItem := range thingDataArray {
+			for key, value := range thingDataItem {
+				if key != "ts" {
+					readings = append(readings, alarmReading{key, int64(thingDataItem["ts"]), value})
+				}
+			}
+		}
+	}
+
+	// Compute the derived sensors from the last value of every sensor, a
+	// derived sensor is only sampled when one of its inputs is
+	currentDataMap := CopyMap(ingest.currentDataMap)
+	for _, thingDataItem := range thingDataArray {
+		for key, value := range thingDataItem {
+			if _, ok := ingest.smallIdToInfoMap[key]; ok {
+				currentDataMap[key] = value
+			}
+		}
+		if ingest.derived.Empty() {
+			continue
+		}
+		values := CopyMap(currentDataMap)
+		for _, key := range ingest.derived.Apply(values) {
+			if !ingest.derived.Reads(key, thingDataItem) {
+				continue
+			}
+			thingDataItem[key] = values[key]
+			if ingest.alarms != nil {
+				readings = append(readings, alarmReading{key, int64(thingDataItem["ts"]), values[key]})
+			}
+		}
+	}
+
+	// Save thing data to the csv file
+	csvOffset, err := ingest.writeCsv(thingDataArray)
+	if err != nil {
+		return NewIngestError(ErrFile, err)
+	}
+
+	// Save thing data in the database, the chunk's csv rows are removed if
+	// this fails
+	var datumArray []*model.Datum
+	for _, thingDataItem := range thingDataArray {
+		for _, smallId := range ingest.smallIds {
+			strSmallId := strconv.Itoa(smallId)
+			value, ok := thingDataItem[strSmallId]
+			if !ok {
+				continue
+			}
+			datumArray = append(datumArray, &model.Datum{
+				SessionId: ingest.sessionId,
+				SensorId:  ingest.smallIdToInfoMap[strSmallId].Id,
+				Value:     value,
+				Timestamp: int64(thingDataItem["ts"]),
+			})
+		}
+	}
+	if perr := ingest.store(ctx, datumArray, flushedEntries); perr != nil {
+		if err := ingest.truncateCsv(csvOffset); err != nil {
+			log.Println("Failed to remove the csv rows of a failed chunk: " + err.Error())
+		}
+		return NewIngestError(ErrDatabase, perr)
+	}
+	ingest.currentDataMap = currentDataMap
+	ingest.Count += len(thingDataArray)
+	if rawChunk != nil {
+		if perr := ingest.rawChunkService.Create(ctx, rawChunk); perr != nil {
+			log.Println("Failed to keep raw data: " + perr.Error())
+		}
+	}
+	ingest.LastTimestamp = int64(thingDataArray[len(thingDataArray)-1]["ts"])
+	if ingest.alarms != nil {
+		ingest.checkAlarms(ctx, readings)
+	}
+	return nil
+}
+
+// Stores the data, along with the flushed entries when they are not nil
+func (ingest *SessionIngest) store(ctx context.Context, datumArray []*model.Datum, flushedEntries *int64) *pgconn.PgError {
+	if flushedEntries == nil {
+		if len(datumArray) == 0 {
+			return nil
+		}
+		return ingest.datumService.CreateMany(ctx, ingest.tx, datumArray)
+	}
+	if perr := ingest.datumService.CreateManyFlushed(ctx, ingest.sessionId, datumArray, *flushedEntries); perr != nil {
+		return perr
+	}
+	ingest.FlushedEntries = *flushedEntries
+	return nil
+}
+
+// Encodes a sorted chunk of thing data as a raw chunk, nil if it cannot be
+func (ingest *SessionIngest) newRawChunk(thingDataArray []map[string]float64) *model.RawChunk {
+	payload, err := json.Marshal(thingDataArray)
+	if err != nil {
+		log.Println("Failed to encode raw data: " + err.Error())
+		return nil
+	}
+	return &model.RawChunk{
+		SessionId:      ingest.sessionId,
+		FirstTimestamp: int64(thingDataArray[0]["ts"]),
+		LastTimestamp:  int64(thingDataArray[len(thingDataArray)-1]["ts"]),
+		Time:           time.Now().UnixMilli(),
+		Payload:        string(payload),
+	}
+}
+
+type alarmReading struct {
+	smallId   string
+	timestamp int64
+	value     float64
+}
+
+// Follows the alarms through a chunk's readings and saves the alarms that
+// changed, alarms that cannot be saved are retried with the next chunk
+func (ingest *SessionIngest) checkAlarms(ctx context.Context, readings []alarmReading) {
+	var changed []*model.Alarm
+	for _, reading := range readings {
+		changed = append(changed, ingest.alarms.Check(reading.smallId, reading.timestamp, reading.value)...)
+	}
+	if dirty := ingest.alarms.Dirty(); len(dirty) > 0 {
+		if perr := ingest.alarmService.SaveMany(ctx, dirty); perr != nil {
+			log.Println("Failed to save alarms: " + perr.Error())
+		} else {
+			ingest.alarms.Saved(dirty)
+		}
+	}
+	if ingest.onAlarm != nil {
+		for _, alarm := range changed {
+			ingest.onAlarm(alarm)
+		}
+	}
+}
+
+// Close flushes and closes the csv file
+func (ingest *SessionIngest) Close() error {
+	ingest.csvWriter.Flush()
+	err := ingest.csvWriter.Error()
+	if cerr := ingest.csvFile.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Sensors without a sample in a row are left empty. Returns the size of the
+// file before the rows, nothing is left of the rows if they cannot be written.
+func (ingest *SessionIngest) writeCsv(thingDataArray []map[string]float64) (int64, error) {
+	ingest.csvWriter.Flush()
+	offset, err := ingest.csvFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	for _, datum := range thingDataArray {
+		row := CreateCsvRow(datum, ingest.smallIds)
+		if err = ingest.csvWriter.Write(row); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		ingest.csvWriter.Flush()
+		err = ingest.csvWriter.Error()
+	}
+	if err != nil {
+		ingest.truncateCsv(offset)
+		return 0, err
+	}
+	return offset, nil
+}
+
+// Removes everything written to the csv file after the offset
+func (ingest *SessionIngest) truncateCsv(offset int64) error {
+	ingest.csvWriter = csv.NewWriter(ingest.csvFile)
+	return ingest.csvFile.Truncate(offset)
+}
@@ -0,0 +1,136 @@
+package subscriber
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+// Stores the data in memory, CreateMany fails while failing is set
+type memoryDatumService struct {
+	services.DatumServiceInterface
+	data           []*model.Datum
+	flushedEntries int64
+	failing        bool
+}
+
+func (service *memoryDatumService) CreateMany(ctx context.Context, tx *gorm.DB, data []*model.Datum) *pgconn.PgError {
+	if service.failing {
+		return &pgconn.PgError{Message: "unavailable"}
+	}
+	service.data = append(service.data, data...)
+	return nil
+}
+
+func (service *memoryDatumService) CreateManyFlushed(ctx context.Context, sessionId uuid.UUID, data []*model.Datum, flushedEntries int64) *pgconn.PgError {
+	if perr := service.CreateMany(ctx, nil, data); perr != nil {
+		return perr
+	}
+	service.flushedEntries = flushedEntries
+	return nil
+}
+
+func TestProcessKeepsNothingFromAFailedChunk(t *testing.T) {
+	session := &model.Session{Base: model.Base{Id: uuid.New()}}
+	sensors := []*model.Sensor{{Base: model.Base{Id: uuid.New()}, SmallId: 1, Name: "speed"}}
+	datumService := &memoryDatumService{failing: true}
+	fileName := filepath.Join(t.TempDir(), "session.csv")
+	ingest, err := NewSessionIngest(session, sensors, datumService, filepath.Dir(fileName), fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ingest.Close()
+
+	chunk := func() []map[string]float64 {
+		return []map[string]float64{{"ts": 1, "1": 10}, {"ts": 2, "1": 20}}
+	}
+	if ierr := ingest.Process(context.Background(), chunk()); ierr == nil || ierr.Kind != ErrDatabase {
+		t.Fatalf("expected a database error, got %v", ierr)
+	}
+	if ingest.Count != 0 {
+		t.Fatalf("expected no samples to be counted, got %d", ingest.Count)
+	}
+
+	// The chunk is processed again once the database is back
+	datumService.failing = false
+	if ierr := ingest.Process(context.Background(), chunk()); ierr != nil {
+		t.Fatal(ierr)
+	}
+	if len(datumService.data) != 2 {
+		t.Fatalf("expected 2 stored samples, got %d", len(datumService.data))
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Timestamp,speed\n1,10\n2,20\n"; string(content) != expected {
+		t.Fatalf("expected the csv %q, got %q", expected, strings.TrimSpace(string(content)))
+	}
+}
+
+func TestProcessStoresNothingWhenTheCsvFails(t *testing.T) {
+	session := &model.Session{Base: model.Base{Id: uuid.New()}}
+	sensors := []*model.Sensor{{Base: model.Base{Id: uuid.New()}, SmallId: 1, Name: "speed"}}
+	datumService := &memoryDatumService{}
+	fileName := filepath.Join(t.TempDir(), "session.csv")
+	ingest, err := NewSessionIngest(session, sensors, datumService, filepath.Dir(fileName), fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingest.csvFile.Close()
+
+	ierr := ingest.Process(context.Background(), []map[string]float64{{"ts": 1, "1": 10}})
+	if ierr == nil || ierr.Kind != ErrFile {
+		t.Fatalf("expected a file error, got %v", ierr)
+	}
+	if len(datumService.data) != 0 {
+		t.Fatalf("expected no stored samples, got %d", len(datumService.data))
+	}
+}
+
+func TestProcessFlushedStoresTheEntriesWithTheData(t *testing.T) {
+	session := &model.Session{Base: model.Base{Id: uuid.New()}, FlushedEntries: 3}
+	sensors := []*model.Sensor{{Base: model.Base{Id: uuid.New()}, SmallId: 1, Name: "speed"}}
+	datumService := &memoryDatumService{flushedEntries: 3}
+	fileName := filepath.Join(t.TempDir(), "session.csv")
+	ingest, err := NewSessionIngest(session, sensors, datumService, filepath.Dir(fileName), fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ingest.Close()
+	if ingest.FlushedEntries != 3 {
+		t.Fatalf("expected the session's 3 flushed entries, got %d", ingest.FlushedEntries)
+	}
+
+	tests := []struct {
+		name     string
+		chunk    []map[string]float64
+		entries  int64
+		failing  bool
+		expected int64
+	}{
+		{"failed chunk", []map[string]float64{{"ts": 1, "1": 10}}, 4, true, 3},
+		{"written chunk", []map[string]float64{{"ts": 1, "1": 10}}, 4, false, 4},
+		{"no samples", nil, 6, false, 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			datumService.failing = test.failing
+			ierr := ingest.ProcessFlushed(context.Background(), test.chunk, test.entries)
+			if (ierr != nil) != test.failing {
+				t.Fatalf("unexpected error %v", ierr)
+			}
+			if ingest.FlushedEntries != test.expected || datumService.flushedEntries != test.expected {
+				t.Fatalf("expected %d flushed entries, got %d in the ingest and %d stored", test.expected, ingest.FlushedEntries, datumService.flushedEntries)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package subscriber
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Live event types published to the session channel
+const (
+	LiveEventData  = "data"
+	LiveEventAlarm = "alarm"
+	LiveEventEnd   = "end"
+)
+
+type LiveEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+type LiveSample struct {
+	Timestamp int64              `json:"ts"`
+	Values    map[string]float64 `json:"values"`
+}
+
+// LiveChannel is the Redis channel decoded data for a session is published to
+func LiveChannel(sessionId uuid.UUID) string {
+	return "SESSION_" + sessionId.String()
+}
+
+func PublishLiveEvent(ctx context.Context, redisClient *redis.Client, sessionId uuid.UUID, event LiveEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Failed to encode live event: " + err.Error())
+		return
+	}
+	if err = redisClient.Publish(ctx, LiveChannel(sessionId), payload).Err(); err != nil {
+		log.Println("Failed to publish live event: " + err.Error())
+	}
+}
+
+// Reads the thing data pushed since the cursor, publishes it calibrated and
+// keyed by sensor ID and returns the new cursor
+func PublishLiveData(
+	ctx context.Context,
+	redisClient *redis.Client,
+	thingId uuid.UUID,
+	sessionId uuid.UUID,
+	smallIdToInfoMap map[string]SensorInfo,
+	decoder *CanDecoder,
+	calibrator *Calibrator,
+	cursor int64,
+) int64 {
+	thingData, err := redisClient.LRange(ctx, thingDataKey(thingId), cursor, -1).Result()
+	if err != nil || len(thingData) == 0 {
+		return cursor
+	}
+
+	// Decode the small ids into sensor ids
+	var samples []LiveSample
+	thingDataArray, _ := ParseThingData(thingData, decoder)
+	for _, thingDataItem := range thingDataArray {
+		if calibrator != nil {
+			calibrator.Calibrate(thingDataItem)
+		}
+		sample := LiveSample{
+			Timestamp: int64(thingDataItem["ts"]),
+			Values:    make(map[string]float64),
+		}
+		for key, value := range thingDataItem {
+			if sensorInfo, ok := smallIdToInfoMap[key]; ok {
+				sample.Values[sensorInfo.Id.String()] = value
+			}
+		}
+		samples = append(samples, sample)
+	}
+
+	// Publish the samples if there are any listeners
+	if len(samples) > 0 {
+		PublishLiveEvent(ctx, redisClient, sessionId, LiveEvent{Type: LiveEventData, Data: samples})
+	}
+	return cursor + int64(len(thingData))
+}
+
+// Parses the thing data JSON, an item may hold several space separated
+// samples. Raw CAN frames are decoded into samples when there is a decoder,
+// frames with the same timestamp are merged and frames that no sensor uses
+// are dropped. Samples that are not valid or have no timestamp are rejected.
+func ParseThingData(thingData []string, decoder *CanDecoder) ([]map[string]float64, []string) {
+	var thingDataArray []map[string]float64
+	var rejected []string
+	frames := make(map[float64]map[string]float64)
+	add := func(thingDataItemMap map[string]float64, frame bool) {
+		if frame && thingDataItemMap == nil {
+			return
+		}
+		if merged, ok := frames[thingDataItemMap["ts"]]; frame && ok {
+			for key, value := range thingDataItemMap {
+				merged[key] = value
+			}
+			return
+		}
+		if frame {
+			frames[thingDataItemMap["ts"]] = thingDataItemMap
+		}
+		thingDataArray = append(thingDataArray, thingDataItemMap)
+	}
+
+	for _, thingDataItem := range thingData {
+		if thingDataItemMap, frame, ok := parseThingDataItem(thingDataItem, decoder); ok {
+			add(thingDataItemMap, frame)
+			continue
+		}
+		for _, sample := range strings.Fields(thingDataItem) {
+			if thingDataItemMap, frame, ok := parseThingDataItem(sample, decoder); ok {
+				add(thingDataItemMap, frame)
+			} else {
+				rejected = append(rejected, sample)
+			}
+		}
+	}
+	return thingDataArray, rejected
+}
+
+// Parses a sample or a raw CAN frame, a valid frame that decodes to nothing
+// returns a nil sample
+func parseThingDataItem(thingDataItem string, decoder *CanDecoder) (map[string]float64, bool, bool) {
+	var thingDataItemMap map[string]float64
+	if err := json.Unmarshal([]byte(thingDataItem), &thingDataItemMap); err == nil {
+		_, hasTimestamp := thingDataItemMap["ts"]
+		return thingDataItemMap, false, hasTimestamp
+	}
+	if decoder == nil {
+		return nil, false, false
+	}
+	frame, ok := parseCanFrame(thingDataItem)
+	if !ok {
+		return nil, false, false
+	}
+	if sample, ok := decoder.Decode(frame); ok {
+		return sample, true, true
+	}
+	return nil, true, true
+}
@@ -0,0 +1,185 @@
+package subscriber
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"database-ms/app/storage"
+	"database-ms/config"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RecoveryReport describes what happened to a session left open by a crash
+type RecoveryReport struct {
+	SessionId       uuid.UUID
+	ThingId         uuid.UUID
+	SalvagedSamples int
+	EndTime         *int64
+	Deleted         bool
+	Err             error
+}
+
+func (report RecoveryReport) String() string {
+	switch {
+	case report.Err != nil:
+		return fmt.Sprintf("Failed to recover session %s: %s", report.SessionId, report.Err)
+	case report.Deleted:
+		return fmt.Sprintf("Deleted session %s, it had no data to recover", report.SessionId)
+	default:
+		return fmt.Sprintf(
+			"Recovered session %s, salvaged %d samples from redis and closed it at %d",
+			report.SessionId, report.SalvagedSamples, *report.EndTime,
+		)
+	}
+}
+
+// RecoverOrphanedSessions closes the generated sessions that were still open
+// when the process stopped, salvaging the data that was left in redis.
+func RecoverOrphanedSessions(ctx context.Context, redisClient *redis.Client, db *gorm.DB, conf *config.Configuration) []RecoveryReport {
+	sessionService := services.NewSessionService(db, conf)
+	sessions, perr := sessionService.FindOpenGeneratedSessions(ctx)
+	if perr != nil {
+		log.Println("Failed to find orphaned sessions: " + perr.Error())
+		return nil
+	}
+
+	// Only the latest session of a thing can own the data left in redis
+	latestSessions := make(map[uuid.UUID]*model.Session)
+	for _, session := range sessions {
+		latestSessions[session.ThingId] = session
+	}
+
+	var reports []RecoveryReport
+	for _, session := range sessions {
+		report := RecoveryReport{SessionId: session.Id, ThingId: session.ThingId}
+		if latestSessions[session.ThingId] == session {
+			report.SalvagedSamples, report.Err = salvageThingData(ctx, redisClient, db, conf, session)
+		}
+		if report.Err == nil {
+			report.EndTime, report.Err = closeSession(ctx, db, conf, session, nil)
+			report.Deleted = report.Err == nil && report.EndTime == nil
+		}
+		PublishLiveEvent(ctx, redisClient, session.Id, LiveEvent{Type: LiveEventEnd})
+		log.Println(report)
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// Writes the data left in redis to the session, returns the number of samples written
+func salvageThingData(ctx context.Context, redisClient *redis.Client, db *gorm.DB, conf *config.Configuration, session *model.Session) (int, error) {
+	// Get the sensors as they were when the session started
+	sensors, perr := services.NewSessionSensorService(db, conf).FindSensorsBySession(ctx, session)
+	if perr != nil {
+		return 0, perr
+	}
+	smallIdToInfoMap := make(map[string]SensorInfo)
+	for _, sensor := range sensors {
+		smallIdToInfoMap[fmt.Sprint(sensor.SmallId)] = SensorInfo{Id: sensor.Id, Name: sensor.Name}
+	}
+
+	// Continue the session's csv file where it stopped
+	fileName := storage.WorkingPath(conf, session)
+	ingest, err := ResumeSessionIngest(session, sensors, services.NewDatumService(db, conf), filepath.Dir(fileName), fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer ingest.Close()
+	err = ingest.DetectAlarms(ctx, services.NewAlarmService(db, conf), func(alarm *model.Alarm) {
+		PublishLiveEvent(ctx, redisClient, session.Id, LiveEvent{Type: LiveEventAlarm, Data: alarm})
+	})
+	if err != nil {
+		log.Println("Failed to start alarm detection: " + err.Error())
+	}
+	if conf.KeepRawData {
+		ingest.KeepRawData(services.NewRawChunkService(db, conf))
+	}
+
+	// Write everything that is left, anything that fails is dead lettered
+	deadLetters := NewDeadLetterStore(db, conf)
+	_, ierr := FlushThingData(ctx, redisClient, session.ThingId, session.Id, ingest, deadLetters, smallIdToInfoMap, NewCanDecoder(sensors), 0)
+	if ierr != nil {
+		DeadLetterThingData(ctx, redisClient, session.ThingId, session.Id, ingest.FlushedEntries, deadLetters, ierr)
+	}
+	return ingest.Count, nil
+}
+
+// Sets the end time of a session and stores its csv file, or deletes the
+// session if it has no data. A nil end time is taken from the session's data.
+// Returns the end time, nil if the session was deleted.
+func closeSession(ctx context.Context, db *gorm.DB, conf *config.Configuration, session *model.Session, endTime *int64) (*int64, error) {
+	sessionService := services.NewSessionService(db, conf)
+	store := storage.NewStorage(conf)
+	fileName := storage.WorkingPath(conf, session)
+	extent, perr := services.NewDatumService(db, conf).FindExtentBySessionId(ctx, session.Id)
+	if perr != nil {
+		return nil, perr
+	}
+
+	// Sessions without any data are deleted
+	if extent.First == nil || extent.Last == nil {
+		os.Remove(fileName)
+		store.Delete(ctx, storage.SessionKey(session))
+		if perr = sessionService.DeleteSession(ctx, session.Id); perr != nil {
+			return nil, perr
+		}
+		return nil, nil
+	}
+
+	// Alarms that are still active end with the data
+	if perr = services.NewAlarmService(db, conf).EndOpenBySessionId(ctx, session.Id, *extent.Last); perr != nil {
+		return nil, perr
+	}
+
+	// Merge the small chunks written while the data came in, the data is
+	// readable either way
+	if perr = services.NewDatumService(db, conf).CompactBySessionId(ctx, session.Id); perr != nil {
+		log.Println("Failed to compact session " + session.Id.String() + ": " + perr.Error())
+	}
+
+	// End the session at its last sample. Timestamps before the start are
+	// relative to the thing's clock so the data's duration is used instead.
+	if endTime == nil {
+		lastTime := *extent.Last
+		if lastTime < session.StartTime {
+			lastTime = session.StartTime + *extent.Last - *extent.First
+		}
+		if now := time.Now().UnixMilli(); lastTime > now {
+			lastTime = now
+		}
+		endTime = &lastTime
+	}
+
+	// Store the csv file, it is kept on the local disk if this fails
+	if _, err := os.Stat(fileName); err == nil {
+		if err = storage.PutFile(ctx, store, storage.SessionKey(session), fileName); err != nil {
+			log.Println("Failed to store the file of session " + session.Id.String() + ": " + err.Error())
+		}
+	}
+
+	// Summarize the session, it is closed even if this fails
+	if _, perr = services.NewSummaryService(db, conf).Compute(ctx, session.Id); perr != nil {
+		log.Println("Failed to summarize session " + session.Id.String() + ": " + perr.Error())
+	}
+
+	// Re-fetch the session in case a user has modified it
+	session, perr = sessionService.FindById(ctx, session.Id)
+	if perr != nil {
+		return nil, perr
+	}
+
+	// Update the session
+	session.EndTime = endTime
+	if perr = sessionService.UpdateSession(ctx, session); perr != nil {
+		return nil, perr
+	}
+	return endTime, nil
+}
@@ -0,0 +1,257 @@
+package subscriber
+
+import (
+	"context"
+	"database-ms/app/model"
+	"database-ms/app/services"
+	"database-ms/app/storage"
+	"database-ms/config"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// States of a reprocessing job
+const (
+	ReprocessRunning = "running"
+	ReprocessDone    = "done"
+	ReprocessFailed  = "failed"
+)
+
+var (
+	ErrReprocessRunning = errors.New("the session is already being reprocessed")
+	ErrSessionLive      = errors.New("the session is still being recorded")
+	ErrNoRawData        = errors.New("the session has no raw data to reprocess")
+)
+
+// ReprocessJob reports the progress of a session being processed again
+type ReprocessJob struct {
+	Id              uuid.UUID `json:"id"`
+	SessionId       uuid.UUID `json:"sessionId"`
+	State           string    `json:"state"`
+	Chunks          int       `json:"chunks"`
+	ProcessedChunks int       `json:"processedChunks"`
+	Samples         int       `json:"samples"`
+	Error           string    `json:"error,omitempty"`
+	StartTime       int64     `json:"startTime"`
+	EndTime         *int64    `json:"endTime,omitempty"`
+}
+
+// Reprocessor processes sessions again from their raw data with the current
+// sensor configuration, which replaces the session's sensor snapshot. Each
+// chunk is calibrated with the calibrations in effect when it was received.
+// Jobs are kept in memory, the last job of a session can be looked up until
+// the process restarts.
+type Reprocessor struct {
+	db   *gorm.DB
+	conf *config.Configuration
+
+	mutex sync.Mutex
+	jobs  map[uuid.UUID]*ReprocessJob
+}
+
+func NewReprocessor(db *gorm.DB, conf *config.Configuration) *Reprocessor {
+	return &Reprocessor{db: db, conf: conf, jobs: make(map[uuid.UUID]*ReprocessJob)}
+}
+
+// Start begins reprocessing a session in the background
+func (reprocessor *Reprocessor) Start(ctx context.Context, session *model.Session) (*ReprocessJob, error) {
+	// Guard against sessions that are still being written
+	if session.IsLive() {
+		return nil, ErrSessionLive
+	}
+
+	// Guard against sessions without raw data
+	count, perr := services.NewRawChunkService(reprocessor.db, reprocessor.conf).CountBySessionId(ctx, session.Id)
+	if perr != nil {
+		return nil, perr
+	}
+	if count == 0 {
+		return nil, ErrNoRawData
+	}
+
+	// Register the job unless one is already running
+	reprocessor.mutex.Lock()
+	defer reprocessor.mutex.Unlock()
+	if job, ok := reprocessor.jobs[session.Id]; ok && job.State == ReprocessRunning {
+		return nil, ErrReprocessRunning
+	}
+	job := &ReprocessJob{
+		Id:        uuid.New(),
+		SessionId: session.Id,
+		State:     ReprocessRunning,
+		Chunks:    int(count),
+		StartTime: time.Now().UnixMilli(),
+	}
+	reprocessor.jobs[session.Id] = job
+	go reprocessor.run(job, session)
+	return job.snapshot(), nil
+}
+
+// Find returns the last job of a session, nil if there is none
+func (reprocessor *Reprocessor) Find(sessionId uuid.UUID) *ReprocessJob {
+	reprocessor.mutex.Lock()
+	defer reprocessor.mutex.Unlock()
+	if job, ok := reprocessor.jobs[sessionId]; ok {
+		return job.snapshot()
+	}
+	return nil
+}
+
+// Must be called with the mutex held
+func (job *ReprocessJob) snapshot() *ReprocessJob {
+	copied := *job
+	return &copied
+}
+
+func (reprocessor *Reprocessor) update(job *ReprocessJob, update func(*ReprocessJob)) {
+	reprocessor.mutex.Lock()
+	defer reprocessor.mutex.Unlock()
+	update(job)
+}
+
+func (reprocessor *Reprocessor) run(job *ReprocessJob, session *model.Session) {
+	ctx := context.Background()
+	err := reprocessor.reprocess(ctx, job, session)
+	reprocessor.update(job, func(job *ReprocessJob) {
+		endTime := time.Now().UnixMilli()
+		job.EndTime = &endTime
+		job.State = ReprocessDone
+		if err != nil {
+			job.State = ReprocessFailed
+			job.Error = err.Error()
+		}
+	})
+	if err != nil {
+		log.Println("Failed to reprocess session " + session.Id.String() + ": " + err.Error())
+	} else {
+		log.Println("Reprocessed session " + session.Id.String())
+	}
+}
+
+// Rewrites the session's data, alarms and csv file from its raw chunks. The
+// data and alarms are replaced in one transaction and the csv file is written
+// on the local disk, it only replaces the stored file once the transaction
+// commits.
+func (reprocessor *Reprocessor) reprocess(ctx context.Context, job *ReprocessJob, session *model.Session) (err error) {
+	db, conf := reprocessor.db, reprocessor.conf
+	tempFileName := storage.WorkingPath(conf, session) + ".reprocess"
+	filePath := filepath.Dir(tempFileName)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Println(recovered)
+			os.Remove(tempFileName)
+			err = errors.New("unexpected error while reprocessing")
+		}
+	}()
+
+	// Attempt to read the raw data and the current sensors
+	chunks, perr := services.NewRawChunkService(db, conf).FindBySessionId(ctx, session.Id)
+	if perr != nil {
+		return perr
+	}
+	sensors, perr := services.NewSensorService(db, conf).FindByThingId(ctx, session.ThingId)
+	if perr != nil {
+		return perr
+	}
+	sensorIds := make([]uuid.UUID, len(sensors))
+	for i, sensor := range sensors {
+		sensorIds[i] = sensor.Id
+	}
+	calibrations, perr := services.NewCalibrationService(db, conf).FindBySensorIds(ctx, sensorIds)
+	if perr != nil {
+		return perr
+	}
+	reprocessor.update(job, func(job *ReprocessJob) {
+		job.Chunks = len(chunks)
+	})
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Remove what was computed the last time
+		datumService := services.NewDatumService(tx, conf)
+		if perr := datumService.DeleteBySessionId(ctx, session.Id); perr != nil {
+			return perr
+		}
+		alarmService := services.NewAlarmService(tx, conf)
+		if perr := alarmService.DeleteBySessionId(ctx, session.Id); perr != nil {
+			return perr
+		}
+
+		// The session is now interpreted with the current sensors
+		if perr := services.NewSessionSensorService(tx, conf).ReplaceBySessionId(ctx, session.Id, sensors); perr != nil {
+			return perr
+		}
+
+		// Process every chunk again
+		ingest, err := NewSessionIngest(session, sensors, datumService, filePath, tempFileName)
+		if err != nil {
+			return err
+		}
+		closed := false
+		defer func() {
+			if !closed {
+				ingest.Close()
+			}
+		}()
+		if err = ingest.DetectAlarms(ctx, alarmService, nil); err != nil {
+			return err
+		}
+		ingest.UseTransaction(tx)
+		ingest.UseCalibrationHistory(calibrations)
+		for _, chunk := range chunks {
+			var thingDataArray []map[string]float64
+			if err := json.Unmarshal([]byte(chunk.Payload), &thingDataArray); err != nil {
+				return errors.New("raw chunk " + chunk.Id.String() + " could not be read: " + err.Error())
+			}
+			ingest.Calibrator().At(chunk.Time)
+			if ierr := ingest.Process(ctx, thingDataArray); ierr != nil {
+				return ierr
+			}
+			reprocessor.update(job, func(job *ReprocessJob) {
+				job.ProcessedChunks++
+				job.Samples = ingest.Count
+			})
+		}
+		closed = true
+		if err = ingest.Close(); err != nil {
+			return err
+		}
+		if perr := datumService.CompactBySessionId(ctx, session.Id); perr != nil {
+			return perr
+		}
+
+		// Alarms that are still active end with the data
+		if ingest.Count > 0 {
+			if perr := alarmService.EndOpenBySessionId(ctx, session.Id, ingest.LastTimestamp); perr != nil {
+				return perr
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		os.Remove(tempFileName)
+		return err
+	}
+
+	// Replace the csv file now that the data is committed, an archive of the
+	// old file is out of date
+	store, key := storage.NewStorage(conf), storage.SessionKey(session)
+	if err = storage.PutFile(ctx, store, key, tempFileName); err != nil {
+		os.Remove(tempFileName)
+		return err
+	}
+	store.Delete(ctx, key+storage.ArchiveExtension)
+
+	// Summarize the session again, the data is kept even if this fails
+	if _, perr := services.NewSummaryService(db, conf).Compute(ctx, session.Id); perr != nil {
+		log.Println("Failed to summarize session " + session.Id.String() + ": " + perr.Error())
+	}
+	return nil
+}
@@ -4,17 +4,16 @@ import (
 	"context"
 	"database-ms/app/model"
 	"database-ms/app/services"
+	"database-ms/app/storage"
 	"database-ms/config"
+	"errors"
 	"fmt"
 	"log"
-	"math"
-	"os"
-	"sort"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
-	"encoding/csv"
 	"encoding/json"
 
 	"github.com/go-redis/redis/v8"
@@ -32,11 +31,11 @@ type SensorInfo struct {
 	Name string
 }
 
-func Initialize(conf *config.Configuration, db *gorm.DB) {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: conf.RedisUrl + ":" + conf.RedisPort,
-		// Password: conf.RedisPassword,
-	})
+// How often data pushed during a session is forwarded to live listeners
+const liveInterval = 500 * time.Millisecond
+
+func Initialize(conf *config.Configuration, db *gorm.DB, redisClient *redis.Client) {
+	RecoverOrphanedSessions(context.Background(), redisClient, db, conf)
 	go AwaitThingDataSessions(redisClient, db, conf)
 }
 
@@ -45,266 +44,319 @@ func AwaitThingDataSessions(redisClient *redis.Client, db *gorm.DB, conf *config
 	subscriber := redisClient.Subscribe(ctx, "THING_CONNECTION")
 	defer subscriber.Close()
 	connectionChannel := subscriber.Channel()
-	sessionService := services.NewSessionService(db, conf)
+	deadLetters := NewDeadLetterStore(db, conf)
 
 	for msg := range connectionChannel {
-		message := Message{}
-		json.Unmarshal([]byte(msg.Payload), &message)
-		if message.Active {
-			thingId, err := uuid.Parse(message.ThingId)
-			if err != nil {
-				log.Println("Failed to read thing ID.")
-				panic(err)
-			}
-			generated := true
-			session := &model.Session{
-				StartTime: time.Now().UnixMilli(),
-				EndTime:   nil,
-				ThingId:   thingId,
-				Name:      uuid.NewString(),
-				Generated: &generated,
-			}
-			perr := sessionService.CreateSession(ctx, session)
-			if perr != nil {
-				log.Println("Failed to create session.")
-				panic(err)
-			}
-			log.Println("Session created with ID: " + session.Id.String())
-			go ThingDataSession(thingId, session, redisClient, db, conf)
+		if ierr := StartThingDataSession(ctx, msg.Payload, redisClient, db, conf); ierr != nil {
+			deadLetters.Save(ctx, nil, nil, ierr, []string{msg.Payload})
+		}
+	}
+}
+
+// Starts a session for a thing connection message, the error is classified
+// so that a bad message never stops the listener
+func StartThingDataSession(
+	ctx context.Context,
+	payload string,
+	redisClient *redis.Client,
+	db *gorm.DB,
+	conf *config.Configuration,
+) (ierr *IngestError) {
+	defer func() {
+		if err := recover(); err != nil {
+			ierr = NewIngestError(ErrInternal, fmt.Errorf("%v", err))
 		}
+	}()
+
+	// Attempt to read the message
+	message := Message{}
+	if err := json.Unmarshal([]byte(payload), &message); err != nil {
+		return NewIngestError(ErrBadPayload, err)
+	}
+	if !message.Active {
+		return nil
+	}
+	thingId, err := uuid.Parse(message.ThingId)
+	if err != nil {
+		return NewIngestError(ErrBadPayload, err)
 	}
+
+	// Attempt to find the thing
+	if _, perr := services.NewThingService(db, conf).FindById(ctx, thingId); perr != nil {
+		return NewIngestError(ErrUnknownThing, errors.New("thing "+thingId.String()+" does not exist"))
+	}
+
+	// Attempt to create the session
+	generated := true
+	session := &model.Session{
+		StartTime: time.Now().UnixMilli(),
+		EndTime:   nil,
+		ThingId:   thingId,
+		Name:      uuid.NewString(),
+		Generated: &generated,
+	}
+	if perr := services.NewSessionService(db, conf).CreateSession(ctx, session); perr != nil {
+		return NewIngestError(ErrDatabase, perr)
+	}
+	log.Println("Session created with ID: " + session.Id.String())
+	go ThingDataSession(thingId, session, redisClient, db, conf)
+	return nil
 }
 
 func ThingDataSession(thingId uuid.UUID, session *model.Session, redisClient *redis.Client, db *gorm.DB, conf *config.Configuration) {
 	ctx := context.Background()
-	subscriber := redisClient.Subscribe(ctx, "THING_"+thingId.String())
+	subscriber := redisClient.Subscribe(ctx, thingDataKey(thingId))
 	defer subscriber.Close()
 	log.Println("Thing Data Session Started for " + thingId.String())
 	thingDataChannel := subscriber.Channel()
 	datumService := services.NewDatumService(db, conf)
-	sessionService := services.NewSessionService(db, conf)
+	deadLetters := NewDeadLetterStore(db, conf)
 
-	// If there is an error anywhere, we want to delete the session
+	// If there is an unexpected error, keep the data that is left and close
+	// the session with what was already written
+	var ingest *SessionIngest
 	defer func() {
 		if err := recover(); err != nil {
-			log.Println("Failed to parse session data, deleting session now...")
-			log.Println(err)
-			if session != nil {
-				sessionService.DeleteSession(ctx, session.Id)
-			}
-		}
-	}()
-
-	for msg := range thingDataChannel {
-		message := Message{}
-		json.Unmarshal([]byte(msg.Payload), &message)
-
-		if !message.Active {
-			// Get thing data from redis
-			thingData, err := redisClient.LRange(ctx, "THING_"+thingId.String(), 0, -1).Result()
-			if err != nil {
-				panic(err)
+			ierr, ok := err.(*IngestError)
+			if !ok {
+				ierr = NewIngestError(ErrInternal, fmt.Errorf("%v", err))
 			}
-
-			// Split thing data if it is a single string
-			if len(thingData) == 1 {
-				thingData = strings.Split(thingData[0], " ")
+			log.Println("Thing Data Session failed for " + thingId.String() + ": " + ierr.Error())
+			flushedEntries := session.FlushedEntries
+			if ingest != nil {
+				flushedEntries = ingest.FlushedEntries
 			}
-
-			// Delete thing data from redis, next connection will clean up if needed
-			redisClient.Del(ctx, "THING_"+thingId.String())
-
-			// Parse thing data from JSON
-			var thingDataArray []map[string]float64
-			for _, thingDataItem := range thingData {
-				var thingDataItemMap map[string]float64
-				json.Unmarshal([]byte(thingDataItem), &thingDataItemMap)
-				thingDataArray = append(thingDataArray, thingDataItemMap)
+			DeadLetterThingData(ctx, redisClient, thingId, session.Id, flushedEntries, deadLetters, ierr)
+			PublishLiveEvent(ctx, redisClient, session.Id, LiveEvent{Type: LiveEventEnd})
+			if ingest != nil {
+				ingest.Close()
 			}
-
-			// Exit if no data is found
-			if len(thingDataArray) == 0 {
-				panic("empty data")
+			endTime := time.Now().UnixMilli()
+			if _, err := closeSession(ctx, db, conf, session, &endTime); err != nil {
+				log.Println("Failed to close session: " + err.Error())
 			}
+		}
+	}()
 
-			// Sort the thing data by timestamp
-			sort.Slice(thingDataArray, func(i, j int) bool {
-				return thingDataArray[i]["ts"] < thingDataArray[j]["ts"]
-			})
+	// Get the sensors as they were when the session started
+	sensors, perr := services.NewSessionSensorService(db, conf).FindSensorsBySession(ctx, session)
+	if perr != nil {
+		panic(NewIngestError(ErrDatabase, perr))
+	}
 
-			// Get sensor list
-			sensorService := services.NewSensorService(db, conf)
-			sensors, perr := sensorService.FindByThingId(ctx, thingId)
-			if perr != nil {
-				panic(err)
-			}
-			sort.Slice(sensors, func(i, j int) bool {
-				return sensors[i].Name < sensors[j].Name
-			})
-
-			// Get small ids in the respective order of the sensors
-			var smallIds []int
-			highestFrequency := 0.0
-			for _, sensor := range sensors {
-				smallIds = append(smallIds, sensor.SmallId)
-				if sensor.Frequency > int32(highestFrequency) {
-					highestFrequency = float64(sensor.Frequency)
-				}
-			}
+	// Map the small ids to sensors so live data can be decoded, raw CAN frames
+	// are decoded with the sensors' CAN ids and offsets
+	smallIdToInfoMap := make(map[string]SensorInfo)
+	for _, sensor := range sensors {
+		smallIdToInfoMap[fmt.Sprint(sensor.SmallId)] = SensorInfo{Id: sensor.Id, Name: sensor.Name}
+	}
+	decoder := NewCanDecoder(sensors)
 
-			// Get the timestamp interval
-			timestampInterval := math.Round(1000 / highestFrequency)
+	// Start writing the session to the database and the .csv file, the file
+	// is stored when the session is closed
+	fileName := storage.WorkingPath(conf, session)
+	ingest, err := NewSessionIngest(session, sensors, datumService, filepath.Dir(fileName), fileName)
+	if err != nil {
+		panic(NewIngestError(ErrFile, err))
+	}
 
-			// Process thing data to fill missing sensor values and missing timestamps
-			thingDataArray = FillMissingValues(thingDataArray, smallIds, int(timestampInterval))
+	// Detect alarms and let live listeners know about them
+	err = ingest.DetectAlarms(ctx, services.NewAlarmService(db, conf), func(alarm *model.Alarm) {
+		PublishLiveEvent(ctx, redisClient, session.Id, LiveEvent{Type: LiveEventAlarm, Data: alarm})
+	})
+	if err != nil {
+		log.Println("Failed to start alarm detection: " + err.Error())
+	}
+	if conf.KeepRawData {
+		ingest.KeepRawData(services.NewRawChunkService(db, conf))
+	}
 
-			// Create map of SmallId to ID and Name
-			smallIdToInfoMap := make(map[string]SensorInfo)
-			for _, sensor := range sensors {
-				smallId := fmt.Sprint(sensor.SmallId)
-				smallIdToInfoMap[smallId] = SensorInfo{Id: sensor.Id, Name: sensor.Name}
+	liveCursor := int64(0)
+	liveTicker := time.NewTicker(liveInterval)
+	defer liveTicker.Stop()
+	flushTicker := time.NewTicker(time.Duration(conf.FlushInterval) * time.Millisecond)
+	defer flushTicker.Stop()
+
+	for {
+		var msg *redis.Message
+		var open bool
+		select {
+		case <-liveTicker.C:
+			// Forward the data pushed since the last tick to live listeners
+			liveCursor = PublishLiveData(ctx, redisClient, thingId, session.Id, smallIdToInfoMap, decoder, ingest.Calibrator(), liveCursor)
+			continue
+		case <-flushTicker.C:
+			// Write the data pushed since the last flush
+			liveCursor, _ = FlushThingData(ctx, redisClient, thingId, session.Id, ingest, deadLetters, smallIdToInfoMap, decoder, liveCursor)
+			continue
+		case msg, open = <-thingDataChannel:
+			if !open {
+				return
 			}
+		}
+		message := Message{}
+		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+			deadLetters.Save(ctx, &thingId, &session.Id, NewIngestError(ErrBadPayload, err), []string{msg.Payload})
+			continue
+		}
 
-			// Re-fetch the session in case a user has modified it
-			session, perr = sessionService.FindById(ctx, session.Id)
-			if perr != nil {
-				panic(err)
+		if !message.Active {
+			// Write the remaining data and let live listeners know the session is over
+			_, ierr := FlushThingData(ctx, redisClient, thingId, session.Id, ingest, deadLetters, smallIdToInfoMap, decoder, liveCursor)
+			if ierr != nil {
+				DeadLetterThingData(ctx, redisClient, thingId, session.Id, ingest.FlushedEntries, deadLetters, ierr)
 			}
-
-			// Update the session
-			endTime := time.Now().UnixMilli()
-			session.EndTime = &endTime
-			perr = sessionService.UpdateSession(ctx, session)
-			if perr != nil {
-				panic(err)
+			PublishLiveEvent(ctx, redisClient, session.Id, LiveEvent{Type: LiveEventEnd})
+			if err = ingest.Close(); err != nil {
+				log.Println("Failed to close session file: " + err.Error())
 			}
 
-			// Save thing data to a .csv file
-			ExportToCsv(
-				thingDataArray,
-				smallIds,
-				smallIdToInfoMap,
-				int(timestampInterval),
-				conf.FilePath+thingId.String(),
-				conf.FilePath+thingId.String()+"/"+session.Name+".csv",
-			)
-
-			// Process non-linear thing data
-			thingDataArray = ReplaceSmallIdsWithIds(thingDataArray, smallIdToInfoMap)
-			var datumArray []*model.Datum
-			for _, thingDataItem := range thingDataArray {
-				for _, smallId := range smallIds {
-					strSmallId := strconv.Itoa(smallId)
-					sensorId := smallIdToInfoMap[strSmallId].Id
-					datumArray = append(datumArray, &model.Datum{
-						SessionId: session.Id,
-						SensorId:  sensorId,
-						Value:     float64(thingDataItem[sensorId.String()]),
-						Timestamp: int64(thingDataItem["ts"]),
-					})
-				}
+			// Close the session, sessions without any data are deleted
+			endTime := time.Now().UnixMilli()
+			closedAt, err := closeSession(ctx, db, conf, session, &endTime)
+			if err != nil {
+				log.Println("Failed to close session: " + err.Error())
+			} else if closedAt == nil {
+				log.Println("Deleted session " + session.Id.String() + ", it had no data")
 			}
 
-			// Save thing data in the database
-			datumService.CreateMany(ctx, datumArray)
-
 			log.Println("Thing Data Session Ended for " + thingId.String())
 			return
 		}
 	}
 }
 
-func FillMissingValues(
-	thingDataArray []map[string]float64,
-	smallIds []int,
-	interval int,
-) []map[string]float64 {
-	// Get the default values of all the sensors
-	currentDataMap := CopyMap(thingDataArray[0])
-	for _, smallId := range smallIds {
-		if _, ok := currentDataMap[strconv.Itoa(smallId)]; !ok {
-			currentDataMap[strconv.Itoa(smallId)] = 0
-		}
+// Writes the thing data pushed so far and removes it from redis, returns the
+// live cursor adjusted for the removed data. Samples that cannot be parsed
+// are dead lettered, a chunk that cannot be written is left in redis. The
+// entries are counted in the session with the data, a chunk that is written
+// but cannot be removed is only removed on the next flush.
+func FlushThingData(
+	ctx context.Context,
+	redisClient *redis.Client,
+	thingId uuid.UUID,
+	sessionId uuid.UUID,
+	ingest *SessionIngest,
+	deadLetters *DeadLetterStore,
+	smallIdToInfoMap map[string]SensorInfo,
+	decoder *CanDecoder,
+	liveCursor int64,
+) (int64, *IngestError) {
+	// Make sure live listeners have seen the data before it is removed
+	liveCursor = PublishLiveData(ctx, redisClient, thingId, sessionId, smallIdToInfoMap, decoder, ingest.Calibrator(), liveCursor)
+	if liveCursor == 0 {
+		return liveCursor, nil
 	}
 
-	// Populate each smallId with its current or previous value
-	for _, thingDataItem := range thingDataArray {
-		for key := range currentDataMap {
-			if _, ok := thingDataItem[key]; !ok {
-				thingDataItem[key] = currentDataMap[key]
-			} else {
-				currentDataMap[key] = thingDataItem[key]
-			}
+	// Remove the entries of a chunk that was written after all
+	trimmed, err := thingDataTrimmed(ctx, redisClient, thingId)
+	if err != nil {
+		log.Println("Failed to read thing data: " + err.Error())
+		return liveCursor, nil
+	}
+	if written := writtenEntries(ingest.FlushedEntries, trimmed, liveCursor); written > 0 {
+		if err = trimThingData(ctx, redisClient, thingId, written); err != nil {
+			log.Println("Failed to trim thing data: " + err.Error())
+			return liveCursor, nil
+		}
+		trimmed += written
+		liveCursor -= written
+		if liveCursor == 0 {
+			return liveCursor, nil
 		}
 	}
 
-	return thingDataArray
-}
-
-func ExportToCsv(
-	thingDataArray []map[string]float64,
-	smallIds []int,
-	smallIdToInfoMap map[string]SensorInfo,
-	interval int,
-	filePath string,
-	fileName string,
-) {
-	// Attempt to create the directory
-	err := os.MkdirAll(filePath, 0777)
+	// Get thing data from redis
+	thingData, err := redisClient.LRange(ctx, thingDataKey(thingId), 0, liveCursor-1).Result()
 	if err != nil {
-		panic(err)
+		log.Println("Failed to read thing data: " + err.Error())
+		return liveCursor, nil
 	}
-
-	// Attempt to create the file
-	csvFile, err := os.Create(fileName)
-	if err != nil {
-		panic(err)
+	if len(thingData) == 0 {
+		return liveCursor, nil
 	}
-	defer csvFile.Close()
 
-	// Create the CSV writer
-	csvWriter := csv.NewWriter(csvFile)
-	defer csvWriter.Flush()
+	// Write the chunk, it is left in redis to retry on the next flush if this fails
+	thingDataArray, rejected := ParseThingData(thingData, decoder)
+	if ierr := ingest.ProcessFlushed(ctx, thingDataArray, trimmed+int64(len(thingData))); ierr != nil {
+		log.Println("Failed to write thing data: " + ierr.Error())
+		return liveCursor, ierr
+	}
+	if len(rejected) > 0 {
+		deadLetters.Save(ctx, &thingId, &sessionId, NewIngestError(ErrBadPayload, errors.New("unparsable thing data")), rejected)
+	}
 
-	// Write header
-	var header []string
-	header = append(header, "Timestamp")
-	for _, smallId := range smallIds {
-		strSmallId := strconv.Itoa(smallId)
-		sensorName := smallIdToInfoMap[strSmallId].Name
-		header = append(header, sensorName)
+	// Remove the written chunk from redis, anything pushed since is kept
+	if err = trimThingData(ctx, redisClient, thingId, int64(len(thingData))); err != nil {
+		log.Println("Failed to trim thing data: " + err.Error())
+		return liveCursor, nil
 	}
-	err = csvWriter.Write(header)
+	return liveCursor - int64(len(thingData)), nil
+}
+
+// Moves all the thing data left in redis to the dead letter store, entries
+// that were already written to the session are only removed. Nothing is
+// dead lettered unless it could be removed so it is never saved twice.
+func DeadLetterThingData(
+	ctx context.Context,
+	redisClient *redis.Client,
+	thingId uuid.UUID,
+	sessionId uuid.UUID,
+	flushedEntries int64,
+	deadLetters *DeadLetterStore,
+	ierr *IngestError,
+) {
+	trimmed, err := thingDataTrimmed(ctx, redisClient, thingId)
 	if err != nil {
-		panic(err)
+		log.Println("Failed to read thing data: " + err.Error())
+		return
+	}
+	thingData, err := redisClient.LRange(ctx, thingDataKey(thingId), 0, -1).Result()
+	if err != nil || len(thingData) == 0 {
+		return
+	}
+	if err = trimThingData(ctx, redisClient, thingId, int64(len(thingData))); err != nil {
+		log.Println("Failed to trim thing data: " + err.Error())
+		return
 	}
+	written := writtenEntries(flushedEntries, trimmed, int64(len(thingData)))
+	deadLetters.Save(ctx, &thingId, &sessionId, ierr, thingData[written:])
+}
 
-	// Write data
-	prevTimestamp := 0
-	var prevRow []string
-	for _, datum := range thingDataArray {
-		// Fill gaps after 0
-		if prevTimestamp != 0 {
-			for int(datum["ts"])-prevTimestamp > interval {
-				err = csvWriter.Write(prevRow)
-				if err != nil {
-					panic(err)
-				}
-				prevTimestamp += interval
-			}
-		}
+// The list of entries pushed by a thing
+func thingDataKey(thingId uuid.UUID) string {
+	return "THING_" + thingId.String()
+}
 
-		// Create the csv row and write to the file
-		row := CreateCsvRow(datum, smallIds)
-		err = csvWriter.Write(row)
-		if err != nil {
-			panic(err)
-		}
+// The number of entries ever removed from the front of a thing's list, the
+// entries in the list are counted from there
+func thingDataTrimmedKey(thingId uuid.UUID) string {
+	return "THING_" + thingId.String() + "_TRIMMED"
+}
 
-		// Set previous values for gap filling
-		prevTimestamp = int(datum["ts"])
-		prevRow = row
+func thingDataTrimmed(ctx context.Context, redisClient *redis.Client, thingId uuid.UUID) (int64, error) {
+	trimmed, err := redisClient.Get(ctx, thingDataTrimmedKey(thingId)).Int64()
+	if err == redis.Nil {
+		return 0, nil
 	}
+	return trimmed, err
+}
+
+// Removes entries from the front of a thing's list and counts them in one
+// transaction, so the count always matches the list
+func trimThingData(ctx context.Context, redisClient *redis.Client, thingId uuid.UUID, count int64) error {
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LTrim(ctx, thingDataKey(thingId), count, -1)
+		pipe.IncrBy(ctx, thingDataTrimmedKey(thingId), count)
+		return nil
+	})
+	return err
+}
+
+// Returns how many of the first length entries of a thing's list were already
+// written to a session that flushed up to flushedEntries
+func writtenEntries(flushedEntries int64, trimmed int64, length int64) int64 {
+	return min(max(flushedEntries-trimmed, 0), length)
 }
 
 func CreateCsvRow(datum map[string]float64, smallIds []int) []string {
@@ -313,26 +365,18 @@ func CreateCsvRow(datum map[string]float64, smallIds []int) []string {
 	timestamp = strings.TrimRight(strings.TrimRight(timestamp, "0"), ".")
 	strArray = append(strArray, timestamp)
 	for _, smallId := range smallIds {
-		stringValue := fmt.Sprintf("%.15f", datum[strconv.Itoa(smallId)])
+		value, ok := datum[strconv.Itoa(smallId)]
+		if !ok {
+			strArray = append(strArray, "")
+			continue
+		}
+		stringValue := fmt.Sprintf("%.15f", value)
 		stringValue = strings.TrimRight(strings.TrimRight(stringValue, "0"), ".")
 		strArray = append(strArray, stringValue)
 	}
 	return strArray
 }
 
-func ReplaceSmallIdsWithIds(
-	thingDataArray []map[string]float64,
-	smallIdToInfoMap map[string]SensorInfo,
-) []map[string]float64 {
-	for _, thingDataItem := range thingDataArray {
-		for smallId, sensorInfo := range smallIdToInfoMap {
-			thingDataItem[sensorInfo.Id.String()] = thingDataItem[smallId]
-			delete(thingDataItem, smallId)
-		}
-	}
-	return thingDataArray
-}
-
 func CopyMap(source map[string]float64) map[string]float64 {
 	dest := make(map[string]float64)
 	for key, value := range source {
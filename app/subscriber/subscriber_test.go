@@ -0,0 +1,25 @@
+package subscriber
+
+import "testing"
+
+func TestWrittenEntries(t *testing.T) {
+	tests := []struct {
+		name           string
+		flushedEntries int64
+		trimmed        int64
+		length         int64
+		expected       int64
+	}{
+		{"all trimmed", 10, 10, 5, 0},
+		{"trim failed", 10, 7, 5, 3},
+		{"new session", 0, 10, 5, 0},
+		{"list shorter than the written entries", 10, 2, 5, 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if written := writtenEntries(test.flushedEntries, test.trimmed, test.length); written != test.expected {
+				t.Fatalf("expected %d written entries, got %d", test.expected, written)
+			}
+		})
+	}
+}
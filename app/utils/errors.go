@@ -37,10 +37,14 @@ const (
 	Forbidden           = "forbidden"
 
 	// Sensor errors
-	SensorsNotFound     = "sensorsNotFound"
-	SensorNotFound      = "sensorNotFound"
-	SensorAlreadyExists = "sensorAlreadyExists"
-	SensorNotUnique     = "sensorNotUnique"
+	SensorsNotFound      = "sensorsNotFound"
+	SensorNotFound       = "sensorNotFound"
+	SensorAlreadyExists  = "sensorAlreadyExists"
+	SensorNotUnique      = "sensorNotUnique"
+	InvalidDbc           = "invalidDbc"
+	NoAvailableSmallIds  = "noAvailableSmallIds"
+	InvalidExpression    = "invalidExpression"
+	CalibrationsNotFound = "calibrationsNotFound"
 
 	// User error
 	UserNotFound    = "userNotFound"
@@ -78,6 +82,19 @@ const (
 	// Datum Error
 	DatumNotFound = "datumNotFound"
 
+	// Alarm Error
+	AlarmsNotFound = "alarmsNotFound"
+
+	// Lap Error
+	LapsNotFound        = "lapsNotFound"
+	LapNotFound         = "lapNotFound"
+	InvalidLapDetection = "invalidLapDetection"
+
+	// Retention Error
+	RetentionPolicyNotFound = "retentionPolicyNotFound"
+	InvalidRetentionPolicy  = "invalidRetentionPolicy"
+	StorageUsageNotFound    = "storageUsageNotFound"
+
 	// Authorization Error
 	MalformedToken   = "malformedToken"
 	ExpiredToken     = "expiredToken"
@@ -94,6 +111,12 @@ const (
 	SessionsNotFound = "sessionsNotFound"
 	SessionNotFound  = "sessionNotFound"
 	SessionNotUnique = "sessionNotUnique"
+	SessionNotLive   = "sessionNotLive"
+	SummaryNotFound  = "summaryNotFound"
+
+	// Reprocess Error
+	CannotReprocess      = "cannotReprocess"
+	ReprocessJobNotFound = "reprocessJobNotFound"
 
 	// Comments Error
 	CommentsNotFound       = "commentsNotFound"
@@ -109,6 +132,10 @@ const (
 	FailedToDeleteFile     = "failedToDeleteFile"
 	FailedToDeleteFiles    = "failedToDeleteFiles"
 	FailedToRenameFile     = "failedToRenameFile"
+	InvalidCsv             = "invalidCsv"
+	CouldNotImportData     = "couldNotImportData"
+	UnsupportedFormat      = "unsupportedFormat"
+	CouldNotExportData     = "couldNotExportData"
 )
 
 // Error code with description
@@ -121,10 +148,14 @@ var errorMessage = map[string]string{
 	"forbidden":           "Forbidden.",
 
 	// Sensor errors
-	"sensorAlreadyExists": "Sensor already exists.",
-	"sensorsNotFound":     "Sensors could not be found.",
-	"sensorNotFound":      "Sensor could not be found.",
-	"sensorNotUnique":     "Sensor name and CAN ID must be unique for a thing.",
+	"sensorAlreadyExists":  "Sensor already exists.",
+	"sensorsNotFound":      "Sensors could not be found.",
+	"sensorNotFound":       "Sensor could not be found.",
+	"sensorNotUnique":      "Sensor name and CAN ID must be unique for a thing.",
+	"invalidDbc":           "The DBC file could not be read.",
+	"noAvailableSmallIds":  "The thing cannot have more than 256 sensors.",
+	"invalidExpression":    "The derived sensor's expression is not valid.",
+	"calibrationsNotFound": "Sensor calibrations could not be found.",
 
 	// User errors
 	"userNotFound":  "User could not be found.",
@@ -164,6 +195,12 @@ var errorMessage = map[string]string{
 	"sessionsNotFound": "Sessions could not be found.",
 	"sessionNotFound":  "Session could not be found.",
 	"sessionNotUnique": "Session name must be unique.",
+	"sessionNotLive":   "Session is not being recorded.",
+	"summaryNotFound":  "Session summary could not be found.",
+
+	// Reprocess errors
+	"cannotReprocess":      "The session cannot be reprocessed.",
+	"reprocessJobNotFound": "The session has not been reprocessed since the server started.",
 
 	// Comment errors
 	"commentsNotFound":       "Comments could not be found.",
@@ -183,6 +220,19 @@ var errorMessage = map[string]string{
 	// Datum
 	"datumNotFound": "Datum could not be found.",
 
+	// Alarm
+	"alarmsNotFound": "Alarms could not be found.",
+
+	// Lap
+	"lapsNotFound":        "Laps could not be found.",
+	"lapNotFound":         "Lap could not be found.",
+	"invalidLapDetection": "The laps could not be detected with the given options.",
+
+	// Retention
+	"retentionPolicyNotFound": "Retention policy could not be found.",
+	"invalidRetentionPolicy":  "Retention durations must be at least one day.",
+	"storageUsageNotFound":    "Storage usage could not be found.",
+
 	// Authorization
 	"malformedToken":   "Token is malformed.",
 	"expiredToken":     "Token is expired.",
@@ -199,4 +249,8 @@ var errorMessage = map[string]string{
 	"failedToDeleteFile":     "Failed to delete file associated with session.",
 	"failedToDeleteFiles":    "Failed to delete files associated with thing.",
 	"failedToRenameFile":     "Failed to rename the session's file.",
+	"invalidCsv":             "The CSV file does not match the thing's sensors.",
+	"couldNotImportData":     "Could not import the file's data.",
+	"unsupportedFormat":      "The requested file format is not supported.",
+	"couldNotExportData":     "Could not export the session's data.",
 }
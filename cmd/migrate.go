@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
 	"database-ms/app/model"
+	"database-ms/app/services"
+	"database-ms/app/storage"
 	"database-ms/config"
+	"flag"
 	"fmt"
+	"os"
 
+	"github.com/google/uuid"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 func main() {
+	// Packing the data of existing sessions can take a while, it is opt-in
+	packDatum := flag.Bool("pack-datum", false, "move the rows of the datum table into datum chunks")
+	flag.Parse()
+
 	// Get the config
 	conf := config.NewConfig("./env")
 
@@ -58,6 +68,11 @@ func main() {
 		panic(err)
 	}
 
+	// The view is recreated after the tables it reads are migrated
+	if result := db.Exec("DROP VIEW IF EXISTS " + model.TableNameDatumSample); result.Error != nil {
+		panic(result.Error)
+	}
+
 	// Full migration
 	db.AutoMigrate(
 		&model.Blacklist{},
@@ -65,10 +80,19 @@ func main() {
 		&model.ChartPreset{},
 		&model.Collection{},
 		&model.Datum{},
+		&model.DatumChunk{},
+		&model.Alarm{},
+		&model.SensorSummary{},
+		&model.Lap{},
+		&model.RawChunk{},
+		&model.RetentionPolicy{},
+		&model.SessionSensor{},
+		&model.DeadLetter{},
 		&model.Operator{},
 		&model.Organization{},
 		&model.RawDataPreset{},
 		&model.Sensor{},
+		&model.SensorCalibration{},
 		&model.Session{},
 		&model.ThingOperator{},
 		&model.Thing{},
@@ -79,5 +103,92 @@ func main() {
 		&model.ChartSensor{},
 	)
 
+	// Data outlives the sensors it belongs to, its metadata is kept in the
+	// sessions' sensor snapshots
+	for _, constraint := range []string{
+		"ALTER TABLE datum DROP CONSTRAINT IF EXISTS fk_datum_sensor",
+		"ALTER TABLE alarm DROP CONSTRAINT IF EXISTS fk_alarm_sensor",
+		"ALTER TABLE sensor_summary DROP CONSTRAINT IF EXISTS fk_sensor_summary_sensor",
+	} {
+		if result := db.Exec(constraint); result.Error != nil {
+			panic(result.Error)
+		}
+	}
+
+	// Snapshot the current sensors for the sessions recorded before snapshots
+	var unsnapshotted []*model.Session
+	result := db.Where("NOT EXISTS (SELECT 1 FROM session_sensor WHERE session_id = session.id)").Find(&unsnapshotted)
+	if result.Error != nil {
+		panic(result.Error)
+	}
+	for _, session := range unsnapshotted {
+		if err := model.SnapshotSessionSensors(session, db); err != nil {
+			panic(err)
+		}
+	}
+
+	// Start the calibration history of sensors that have none
+	var uncalibrated []*model.Sensor
+	result = db.Where("type <> ? AND NOT EXISTS (SELECT 1 FROM sensor_calibration WHERE sensor_id = sensor.id)", model.SensorTypeDerived).
+		Find(&uncalibrated)
+	if result.Error != nil {
+		panic(result.Error)
+	}
+	for _, sensor := range uncalibrated {
+		calibration := model.CalibrationOf(sensor)
+		calibration.Version = 1
+		if result = db.Create(calibration); result.Error != nil {
+			panic(result.Error)
+		}
+	}
+
+	// Data is read through a view of the chunks and the unpacked rows
+	if result = db.Exec(model.DatumSampleView); result.Error != nil {
+		panic(result.Error)
+	}
+
+	// Move the unpacked rows into chunks one session at a time
+	if *packDatum {
+		var sessionIds []uuid.UUID
+		result = db.Model(&model.Datum{}).Distinct("session_id").Pluck("session_id", &sessionIds)
+		if result.Error != nil {
+			panic(result.Error)
+		}
+		datumService := services.NewDatumService(db, conf)
+		for i, sessionId := range sessionIds {
+			if perr := datumService.CompactBySessionId(context.Background(), sessionId); perr != nil {
+				panic(perr)
+			}
+			fmt.Printf("Packed session %s (%d/%d)\n", sessionId, i+1, len(sessionIds))
+		}
+	}
+
+	// Files were named after their session, they are now stored by the
+	// session's id. Files of open sessions stay on the local disk until the
+	// session is closed.
+	var sessions []*model.Session
+	if result = db.Find(&sessions); result.Error != nil {
+		panic(result.Error)
+	}
+	store := storage.NewStorage(conf)
+	for _, session := range sessions {
+		oldName := conf.FilePath + session.ThingId.String() + "/" + session.Name + ".csv"
+		key := storage.SessionKey(session)
+		for _, extension := range []string{"", storage.ArchiveExtension} {
+			if _, err := os.Stat(oldName + extension); err != nil {
+				continue
+			}
+			if session.EndTime == nil {
+				err = os.Rename(oldName+extension, storage.WorkingPath(conf, session)+extension)
+			} else {
+				err = storage.PutFile(context.Background(), store, key+extension, oldName+extension)
+			}
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("Moved %s to %s\n", oldName+extension, key+extension)
+		}
+	}
+
 	println("Finished migration.")
 }
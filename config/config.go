@@ -25,9 +25,22 @@ type Configuration struct {
 	RedisPort     string `env:"REDIS_PORT,required"`
 	// RedisUsername string `env:"REDIS_USERNAME,required"`
 	// RedisPassword string `env:"REDIS_PASSWORD,required"`
-	FilePath string `env:"FILE_PATH,required"`
+	FilePath          string `env:"FILE_PATH,required"`
+	FlushInterval     int    `env:"FLUSH_INTERVAL_MS" envDefault:"5000"`
+	KeepRawData       bool   `env:"KEEP_RAW_DATA" envDefault:"false"`
+	CopyBatchSize     int    `env:"DATUM_COPY_BATCH_SIZE" envDefault:"50000"`
+	RetentionInterval int    `env:"RETENTION_INTERVAL_MINUTES" envDefault:"60"`
+	StorageBackend    string `env:"STORAGE_BACKEND" envDefault:"local"`
+	S3Endpoint        string `env:"S3_ENDPOINT"`
+	S3Region          string `env:"S3_REGION" envDefault:"us-east-1"`
+	S3Bucket          string `env:"S3_BUCKET"`
+	S3AccessKey       string `env:"S3_ACCESS_KEY_ID"`
+	S3SecretKey       string `env:"S3_SECRET_ACCESS_KEY"`
 }
 
+// Used when FLUSH_INTERVAL_MS is not positive, matches its default
+const defaultFlushInterval = 5000
+
 // NewConfig will read the config data from given .env file
 func NewConfig(files ...string) *Configuration {
 	path, _ := os.Getwd()
@@ -52,5 +65,11 @@ func NewConfig(files ...string) *Configuration {
 		cfg.FilePath = cfg.FilePath + "/"
 	}
 
+	// Guard against flush intervals that would stop every session
+	if cfg.FlushInterval <= 0 {
+		log.Printf("FLUSH_INTERVAL_MS must be positive, using %dms instead of %d\n", defaultFlushInterval, cfg.FlushInterval)
+		cfg.FlushInterval = defaultFlushInterval
+	}
+
 	return &cfg
 }
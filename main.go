@@ -2,6 +2,7 @@ package main
 
 import (
 	"database-ms/app/databases"
+	"database-ms/app/retention"
 	"database-ms/app/subscriber"
 	"database-ms/config"
 	"log"
@@ -20,14 +21,20 @@ func main() {
 	db := databases.InitPostgres(conf)
 	// defer db.Close() -> Need to close somehow?
 
+	// Connect to Redis
+	redisClient := databases.InitRedis(conf)
+
 	// Router
 	router := gin.Default()
-	InitializeRoutes(router, db, conf)
+	InitializeRoutes(router, db, redisClient, conf)
 	router.Use(cors.Default())
 	gin.SetMode(gin.ReleaseMode)
 
 	// Redis IoT Sub
-	subscriber.Initialize(conf, db)
+	subscriber.Initialize(conf, db, redisClient)
+
+	// Expire old data
+	retention.NewEnforcer(db, conf).Start()
 
 	// Server config
 	srv := &http.Server{
@@ -4,32 +4,46 @@ import (
 	handlers "database-ms/app/handlers"
 	middleware "database-ms/app/middleware"
 	services "database-ms/app/services"
+	storage "database-ms/app/storage"
+	subscriber "database-ms/app/subscriber"
 	config "database-ms/config"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
-func InitializeRoutes(c *gin.Engine, db *gorm.DB, conf *config.Configuration) {
+func InitializeRoutes(c *gin.Engine, db *gorm.DB, redisClient *redis.Client, conf *config.Configuration) {
 	// Initialize APIs
+	store := storage.NewStorage(conf)
 	organizationService := services.NewOrganizationService(db, conf)
 	organizationAPI := handlers.NewOrganizationAPI(organizationService)
 	userAPI := handlers.NewUserAPI(services.NewUserService(db, conf))
 	authAPI := handlers.NewAuthAPI(services.NewUserService(db, conf), organizationService)
 	thingService := services.NewThingService(db, conf)
-	thingAPI := handlers.NewThingAPI(thingService, conf.FilePath)
+	thingAPI := handlers.NewThingAPI(thingService, store)
 	sensorService := services.NewSensorService(db, conf)
-	sensorAPI := handlers.NewSensorAPI(sensorService, thingService)
+	sensorAPI := handlers.NewSensorAPI(sensorService, thingService, services.NewCalibrationService(db, conf))
 	operatorService := services.NewOperatorService(db, conf)
 	operatorAPI := handlers.NewOperatorAPI(operatorService)
 	sessionService := services.NewSessionService(db, conf)
-	sessionAPI := handlers.NewSessionAPI(sessionService, thingService, conf.FilePath)
+	datumService := services.NewDatumService(db, conf)
+	summaryService := services.NewSummaryService(db, conf)
+	sessionSensorService := services.NewSessionSensorService(db, conf)
+	sessionAPI := handlers.NewSessionAPI(sessionService, thingService, sessionSensorService, datumService, summaryService, redisClient, store)
 	collectionService := services.NewCollectionService(db, conf)
 	collectionAPI := handlers.NewCollectionAPI(collectionService, thingService)
 	commentAPI := handlers.NewCommentAPI(services.NewCommentService(db, conf), thingService, sessionService, sensorService, operatorService, collectionService)
-	rawDataPresetAPI := handlers.NewRawDataPresetAPI(services.NewRawDataPresetService(db, conf), thingService)
-	chartPresetAPI := handlers.NewChartPresetAPI(services.NewChartPresetService(db, conf), thingService)
-	datumAPI := handlers.NewDatumAPI(services.NewDatumService(db, conf), thingService, sensorService, sessionService)
+	rawDataPresetService := services.NewRawDataPresetService(db, conf)
+	rawDataPresetAPI := handlers.NewRawDataPresetAPI(rawDataPresetService, thingService)
+	chartPresetService := services.NewChartPresetService(db, conf)
+	chartPresetAPI := handlers.NewChartPresetAPI(chartPresetService, thingService)
+	datumAPI := handlers.NewDatumAPI(datumService, thingService, sessionSensorService, sessionService, chartPresetService, rawDataPresetService)
+	compareAPI := handlers.NewCompareAPI(services.NewCompareService(db, conf), collectionService, sessionService, sessionSensorService, thingService)
+	alarmAPI := handlers.NewAlarmAPI(services.NewAlarmService(db, conf), sessionService, thingService)
+	reprocessAPI := handlers.NewReprocessAPI(subscriber.NewReprocessor(db, conf), sessionService, thingService)
+	retentionAPI := handlers.NewRetentionAPI(services.NewRetentionService(db, conf), store)
+	lapAPI := handlers.NewLapAPI(services.NewLapService(db, conf), datumService, sessionService, sessionSensorService, collectionService, thingService)
 
 	// Declare public endpoints
 	publicEndpoints := c.Group("")
@@ -63,6 +77,9 @@ func InitializeRoutes(c *gin.Engine, db *gorm.DB, conf *config.Configuration) {
 			organizationEndpoints.GET("", organizationAPI.GetOrganization)
 			organizationEndpoints.PUT("", organizationAPI.UpdateOrganization)
 			organizationEndpoints.PUT("/issueNewAPIKey", organizationAPI.IssueNewAPIKey)
+			organizationEndpoints.GET("/retention", retentionAPI.GetRetentionPolicy)
+			organizationEndpoints.PUT("/retention", retentionAPI.UpdateRetentionPolicy)
+			organizationEndpoints.GET("/storage", retentionAPI.GetStorageUsage)
 			organizationEndpoints.DELETE("/:organizationId", organizationAPI.DeleteOrganization)
 		}
 
@@ -87,10 +104,14 @@ func InitializeRoutes(c *gin.Engine, db *gorm.DB, conf *config.Configuration) {
 			sensorEndpoints.POST("", sensorAPI.CreateSensor)
 			sensorEndpoints.PUT("", sensorAPI.UpdateSensor)
 			sensorEndpoints.DELETE("/:sensorId", sensorAPI.DeleteSensor)
+			sensorEndpoints.GET("/:sensorId/calibrations", sensorAPI.GetCalibrations)
 			thingIdEndpoints := sensorEndpoints.Group("/thing/:thingId")
 			{
 				thingIdEndpoints.GET("", sensorAPI.FindThingSensors)
 				thingIdEndpoints.GET("/lastUpdate/:lastUpdate", sensorAPI.FindUpdatedSensors)
+				thingIdEndpoints.GET("/dbc", sensorAPI.ExportDbc)
+				thingIdEndpoints.POST("/dbc", sensorAPI.ImportDbc)
+				thingIdEndpoints.GET("/schema", sensorAPI.GetSensorSchema)
 			}
 		}
 
@@ -110,6 +131,11 @@ func InitializeRoutes(c *gin.Engine, db *gorm.DB, conf *config.Configuration) {
 			sessionEndpoints.DELETE("/:sessionId", sessionAPI.DeleteSession)
 			sessionEndpoints.POST("/:sessionId/file", sessionAPI.UploadFile)
 			sessionEndpoints.GET("/:sessionId/file", sessionAPI.DownloadFile)
+			sessionEndpoints.GET("/:sessionId/live", sessionAPI.StreamLiveData)
+			sessionEndpoints.GET("/:sessionId/summary", sessionAPI.GetSummary)
+			sessionEndpoints.GET("/:sessionId/sensors", sessionAPI.GetSessionSensors)
+			sessionEndpoints.POST("/:sessionId/reprocess", reprocessAPI.ReprocessSession)
+			sessionEndpoints.GET("/:sessionId/reprocess", reprocessAPI.GetReprocessJob)
 		}
 
 		collectionEndpoints := privateEndpoints.Group("/collections")
@@ -118,6 +144,7 @@ func InitializeRoutes(c *gin.Engine, db *gorm.DB, conf *config.Configuration) {
 			collectionEndpoints.GET("/thing/:thingId", collectionAPI.GetCollections)
 			collectionEndpoints.PUT("", collectionAPI.UpdateCollections)
 			collectionEndpoints.DELETE("/:collectionId", collectionAPI.DeleteCollection)
+			collectionEndpoints.GET("/:collectionId/compare", compareAPI.CompareCollection)
 		}
 
 		commentEndpoints := privateEndpoints.Group("/comments")
@@ -146,7 +173,21 @@ func InitializeRoutes(c *gin.Engine, db *gorm.DB, conf *config.Configuration) {
 
 		dataEndpoints := privateEndpoints.Group("/data")
 		{
+			dataEndpoints.GET("/session/:sessionId", datumAPI.GetSessionData)
 			dataEndpoints.GET("/session/:sessionId/sensor/:sensorId", datumAPI.GetSensorData)
 		}
+
+		alarmEndpoints := privateEndpoints.Group("/alarms")
+		{
+			alarmEndpoints.GET("/session/:sessionId", alarmAPI.GetSessionAlarms)
+		}
+
+		lapEndpoints := privateEndpoints.Group("/laps")
+		{
+			lapEndpoints.POST("/session/:sessionId", lapAPI.DetectLaps)
+			lapEndpoints.GET("/session/:sessionId", lapAPI.GetSessionLaps)
+			lapEndpoints.GET("/:lapId/data", lapAPI.GetLapData)
+			lapEndpoints.GET("/collection/:collectionId/fastest", lapAPI.GetFastestLap)
+		}
 	}
 }